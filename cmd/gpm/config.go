@@ -0,0 +1,66 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/maloquacious/ml_i/pkg/gpm"
+	"github.com/peterbourgon/ff/v3"
+	"os"
+	"unicode/utf8"
+)
+
+type config struct {
+	markers gpm.Markers
+	sources []string
+}
+
+func getConfig() (*config, error) {
+	// create the config structure with default values
+	cfg := &config{
+		markers: gpm.DefaultMarkers,
+	}
+
+	// create a flag set and then parse the command line (and optional configuration file)
+	fs := flag.NewFlagSet("gpm", flag.ContinueOnError)
+	var (
+		_          = fs.String("config", "", "config file (optional, json)")
+		call       = fs.String("call", string(cfg.markers.Call), "marker that starts a macro call")
+		separator  = fs.String("separator", string(cfg.markers.Separator), "marker that separates arguments")
+		end        = fs.String("end", string(cfg.markers.End), "marker that ends a macro call")
+		param      = fs.String("param", string(cfg.markers.Param), "marker that inserts an argument")
+		quoteOpen  = fs.String("quote-open", string(cfg.markers.QuoteOpen), "marker that starts quoted text")
+		quoteClose = fs.String("quote-close", string(cfg.markers.QuoteClose), "marker that ends quoted text")
+	)
+	if err := ff.Parse(fs, os.Args[1:], ff.WithEnvVarPrefix("GPM"), ff.WithConfigFileFlag("config"), ff.WithConfigFileParser(ff.JSONParser), ff.WithIgnoreUndefined(false)); err != nil {
+		return nil, err
+	}
+
+	// each marker must be a single character
+	for _, m := range []struct {
+		name   string
+		value  string
+		marker *rune
+	}{
+		{"call", *call, &cfg.markers.Call},
+		{"separator", *separator, &cfg.markers.Separator},
+		{"end", *end, &cfg.markers.End},
+		{"param", *param, &cfg.markers.Param},
+		{"quote-open", *quoteOpen, &cfg.markers.QuoteOpen},
+		{"quote-close", *quoteClose, &cfg.markers.QuoteClose},
+	} {
+		if utf8.RuneCountInString(m.value) != 1 {
+			return nil, fmt.Errorf("--%s: want single character: got %q", m.name, m.value)
+		}
+		*m.marker, _ = utf8.DecodeRuneInString(m.value)
+	}
+
+	// any remaining arguments are source files. if there are none,
+	// the input is read from stdin.
+	cfg.sources = fs.Args()
+
+	return cfg, nil
+}
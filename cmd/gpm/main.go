@@ -0,0 +1,47 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+// Package main implements Strachey's General Purpose Macrogenerator.
+// It reads text from files (or stdin) and writes the result to stdout.
+package main
+
+import (
+	"github.com/maloquacious/ml_i/pkg/gpm"
+	"io"
+	"log"
+	"os"
+)
+
+func main() {
+	log.SetFlags(0)
+
+	cfg, err := getConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := run(cfg); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(cfg *config) error {
+	// open the source files. if there are none, read from stdin.
+	var sources []io.Reader
+	for _, name := range cfg.sources {
+		fp, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		sources = append(sources, fp)
+	}
+	if len(sources) == 0 {
+		sources = append(sources, os.Stdin)
+	}
+
+	p := gpm.New()
+	p.Markers = cfg.markers
+	return p.Process(io.MultiReader(sources...), os.Stdout)
+}
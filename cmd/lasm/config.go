@@ -8,39 +8,116 @@ import (
 	"flag"
 	"fmt"
 	"github.com/peterbourgon/ff/v3"
-	"os"
+	"strings"
+	"time"
 )
 
 type config struct {
+	command    string   // run (the default), debug, dap, tracediff, or inspect
+	args       []string // arguments after the flags
 	version    string
 	debug      bool
 	sourcefile string
-	test       struct {
+	inputfile  string
+	entry      string // label to start at instead of BEGIN
+	erlso      string // label to branch to on stack overflow instead of ERLSO
+	stackSize  int    // words reserved for the stacks
+	wordSize   int    // bits in a machine word, zero for unbounded
+	maxSteps   int
+	timeout    time.Duration
+	context    int // steps of context shown by tracediff
+	against    struct {
+		sourcefile string
+		inputfile  string
+	}
+	trace struct {
+		file   string
+		format string
+	}
+	profile struct {
+		file   string
+		format string
+	}
+	coverage struct {
+		listing string
+		html    string
+	}
+	snapshot struct {
+		from string // restore the machine from this file instead of assembling
+		to   string // write the machine to this file when the run stops
+	}
+	record string // write a recording of the run to this file
+	core   string // write the machine to this file when the run fails
+	replay string // replay the recording in this file instead of assembling
+	test   struct {
 		astParser bool
 		cstParser bool
 		scanner   bool
 	}
 }
 
-func getConfig() (*config, error) {
+func getConfig(args []string) (*config, error) {
 	// create the config structure with default values
 	cfg := &config{
+		command: "run",
 		version: "L4A",
 	}
 
+	// the command, if given, must be the first argument
+	if len(args) != 0 && !strings.HasPrefix(args[0], "-") {
+		cfg.command, args = args[0], args[1:]
+	}
+
 	// create a flag set and then parse the command line (and optional configuration file)
 	fs := flag.NewFlagSet("my-program", flag.ContinueOnError)
 	var (
 		_ = fs.String("config", "", "config file (optional, json)")
 	)
 	fs.StringVar(&cfg.sourcefile, "source", cfg.sourcefile, "assembly source file (required)")
+	fs.StringVar(&cfg.inputfile, "input", cfg.inputfile, "file read by the program (optional)")
+	fs.StringVar(&cfg.entry, "entry", cfg.entry, "label to start the program at instead of BEGIN (optional)")
+	fs.StringVar(&cfg.erlso, "erlso", cfg.erlso, "label to branch to on stack overflow instead of ERLSO (optional)")
+	fs.IntVar(&cfg.stackSize, "stack-size", cfg.stackSize, "words reserved for the stacks after the program (optional)")
+	fs.IntVar(&cfg.wordSize, "word-size", cfg.wordSize, "bits in a machine word, 16 or 32, zero for unbounded (optional)")
+	fs.IntVar(&cfg.maxSteps, "max-steps", cfg.maxSteps, "maximum steps to run, -1 for unlimited (optional)")
+	fs.DurationVar(&cfg.timeout, "timeout", cfg.timeout, "maximum time to run (optional)")
+	fs.StringVar(&cfg.against.sourcefile, "against", cfg.against.sourcefile, "tracediff: source file to compare with (optional, defaults to --source)")
+	fs.StringVar(&cfg.against.inputfile, "against-input", cfg.against.inputfile, "tracediff: input file to compare with (optional, defaults to --input)")
+	fs.IntVar(&cfg.context, "context", 5, "tracediff: number of preceding steps to show (optional)")
+	fs.StringVar(&cfg.trace.file, "trace", cfg.trace.file, "write an execution trace to this file (optional)")
+	fs.StringVar(&cfg.trace.format, "trace-format", "json", "format of the execution trace, json or text (optional)")
+	fs.StringVar(&cfg.profile.file, "profile", cfg.profile.file, "write an execution profile to this file (optional)")
+	fs.StringVar(&cfg.profile.format, "profile-format", "pprof", "format of the execution profile, pprof or text (optional)")
+	fs.StringVar(&cfg.coverage.listing, "coverage", cfg.coverage.listing, "write a listing with execution counts to this file (optional)")
+	fs.StringVar(&cfg.coverage.html, "coverage-html", cfg.coverage.html, "write an HTML coverage report to this file (optional)")
+	fs.StringVar(&cfg.snapshot.from, "from-snapshot", cfg.snapshot.from, "run the machine saved in this snapshot file instead of the source (optional)")
+	fs.StringVar(&cfg.snapshot.to, "snapshot", cfg.snapshot.to, "write a snapshot of the machine to this file when the run stops (optional)")
+	fs.StringVar(&cfg.record, "record", cfg.record, "write a recording of the run to this file for replay (optional)")
+	fs.StringVar(&cfg.replay, "replay", cfg.replay, "replay the run recorded in this file instead of the source (optional)")
+	fs.StringVar(&cfg.core, "core", cfg.core, "write the machine to this file when the run fails (optional)")
 	fs.BoolVar(&cfg.test.scanner, "test-scanner", cfg.test.scanner, "test scanner, then exit")
 	fs.BoolVar(&cfg.test.cstParser, "test-cst-parser", cfg.test.cstParser, "test cst parser, then exit")
 	fs.BoolVar(&cfg.test.astParser, "test-ast-parser", cfg.test.astParser, "test ast parser, then exit")
 	fs.BoolVar(&cfg.debug, "debug", cfg.debug, "log debug information (optional)")
-	if err := ff.Parse(fs, os.Args[1:], ff.WithEnvVarPrefix("LASM"), ff.WithConfigFileFlag("config"), ff.WithConfigFileParser(ff.JSONParser), ff.WithIgnoreUndefined(false)); err != nil {
+	if err := ff.Parse(fs, args, ff.WithEnvVarPrefix("LASM"), ff.WithConfigFileFlag("config"), ff.WithConfigFileParser(ff.JSONParser), ff.WithIgnoreUndefined(false)); err != nil {
 		return nil, err
-	} else if cfg.sourcefile == "" {
+	}
+
+	// collect the arguments after the flags, allowing more flags to follow them
+	for args = fs.Args(); len(args) != 0; args = fs.Args() {
+		n := 0
+		for n < len(args) && !strings.HasPrefix(args[n], "-") {
+			n++
+		}
+		cfg.args = append(cfg.args, args[:n]...)
+		if n == len(args) {
+			break
+		} else if err := fs.Parse(args[n:]); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.sourcefile == "" && cfg.replay == "" && ((cfg.command == "run" && cfg.snapshot.from == "") || cfg.command == "debug") {
 		return nil, fmt.Errorf("--source is required")
 	}
 
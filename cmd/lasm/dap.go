@@ -0,0 +1,21 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package main
+
+import (
+	"github.com/maloquacious/ml_i/pkg/lowl/dap"
+	"github.com/maloquacious/ml_i/pkg/lowl/vm"
+	"os"
+)
+
+// serveDAP serves the Debug Adapter Protocol on stdin and stdout.
+// The program to debug is named by the client's launch request. It is
+// assembled with the options from the command line, without writing
+// anything to stdout, which belongs to the protocol, or to the listing files.
+func serveDAP(cfg *config) error {
+	return dap.NewServer(os.Stdin, os.Stdout, func(program string) (*vm.VM, error) {
+		return assemble(cfg, program, nil)
+	}).Serve()
+}
@@ -0,0 +1,256 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/maloquacious/ml_i/pkg/lowl/debugger"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const debugHelp = `commands:
+  break|b SPEC         set a breakpoint (SPEC is a label, an address, or line:N)
+  delete|d [SPEC]      delete a breakpoint (all breakpoints if SPEC is omitted)
+  watch|w NAME         stop when the variable NAME changes
+  unwatch NAME         delete a watchpoint
+  info                 list breakpoints and watchpoints
+  step|s [N]           execute N instructions (default 1)
+  next|n               step over a GOSUB
+  finish|f             run until the current subroutine exits
+  continue|c           run until a breakpoint, a watchpoint, or the end
+  back|bs [N]          step backwards N instructions (default 1)
+  rcontinue|rc         run backwards until a breakpoint, a watchpoint, or the start
+  registers|r          print the registers
+  rs                   print the return stack
+  stacks               print the forwards and backwards stacks
+  x ADDR [N]           print N words of memory starting at a label or address
+  list|l [SPEC]        print the source around SPEC (default is the PC)
+  snapshot FILE        save the machine to FILE (run it with lasm run --from-snapshot)
+  record FILE          save a recording of the run to FILE (replay it with --replay)
+  restart              run the program again from the start
+  quit|q               leave the debugger
+an empty line repeats the previous command.
+`
+
+// debug runs the program under the interactive debugger.
+func debug(cfg *config) error {
+	m, closeInput, err := load(cfg)
+	if m == nil || err != nil {
+		return err
+	}
+	defer closeInput()
+
+	closeTrace, err := openTrace(cfg, m)
+	if err != nil {
+		return err
+	}
+	defer closeTrace()
+
+	if m.Registers.Suspended {
+		m.AddOutput("stdout", os.Stdout)
+		m.Streams.Messages = os.Stderr
+	} else {
+		m.Reset(os.Stdout, os.Stderr)
+	}
+	d := debugger.New(m)
+	return debugLoop(d, cfg.sourcefile, os.Stdin, os.Stdout)
+}
+
+// debugLoop reads and executes debugger commands until the input ends
+// or the user quits.
+func debugLoop(d *debugger.Debugger, sourcefile string, r io.Reader, w io.Writer) error {
+	_, _ = fmt.Fprintf(w, "stopped at %s\n", d.Where())
+	printSource(w, d, sourcefile, d.VM().PC, 0)
+
+	scanner, previous := bufio.NewScanner(r), ""
+	for {
+		_, _ = fmt.Fprintf(w, "(lowl) ")
+		if !scanner.Scan() {
+			_, _ = fmt.Fprintln(w)
+			return scanner.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			line = previous
+		}
+		previous = line
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+
+		switch cmd, args := args[0], args[1:]; cmd {
+		case "break", "b":
+			if len(args) != 1 {
+				_, _ = fmt.Fprintf(w, "usage: break SPEC\n")
+			} else if pc, err := d.Break(args[0]); err != nil {
+				_, _ = fmt.Fprintf(w, "error: %v\n", err)
+			} else {
+				_, _ = fmt.Fprintf(w, "breakpoint at %s\n", d.Describe(pc))
+			}
+		case "delete", "d":
+			if len(args) == 0 {
+				d.ClearAll()
+			} else if _, err := d.Clear(args[0]); err != nil {
+				_, _ = fmt.Fprintf(w, "error: %v\n", err)
+			}
+		case "watch", "w":
+			if len(args) != 1 {
+				_, _ = fmt.Fprintf(w, "usage: watch NAME\n")
+			} else if err := d.Watch(args[0]); err != nil {
+				_, _ = fmt.Fprintf(w, "error: %v\n", err)
+			} else {
+				_, _ = fmt.Fprintf(w, "watching %s\n", args[0])
+			}
+		case "unwatch":
+			if len(args) != 1 {
+				_, _ = fmt.Fprintf(w, "usage: unwatch NAME\n")
+			} else if err := d.Unwatch(args[0]); err != nil {
+				_, _ = fmt.Fprintf(w, "error: %v\n", err)
+			}
+		case "info":
+			for _, pc := range d.Breakpoints() {
+				_, _ = fmt.Fprintf(w, "breakpoint %s\n", d.Describe(pc))
+			}
+			for _, name := range d.Watches() {
+				_, _ = fmt.Fprintf(w, "watchpoint %s\n", name)
+			}
+		case "step", "s":
+			n := 1
+			if len(args) != 0 {
+				if n, _ = strconv.Atoi(args[0]); n < 1 {
+					n = 1
+				}
+			}
+			var stop debugger.Stop
+			for ; n > 0; n-- {
+				if stop = d.Step(); stop.Reason != debugger.Stepped {
+					break
+				}
+			}
+			printStop(w, d, sourcefile, stop)
+		case "next", "n":
+			printStop(w, d, sourcefile, d.StepOver())
+		case "finish", "f":
+			printStop(w, d, sourcefile, d.StepOut())
+		case "continue", "c":
+			printStop(w, d, sourcefile, d.Continue())
+		case "back", "bs":
+			n := 1
+			if len(args) != 0 {
+				if n, _ = strconv.Atoi(args[0]); n < 1 {
+					n = 1
+				}
+			}
+			var stop debugger.Stop
+			for ; n > 0; n-- {
+				if stop = d.StepBack(); stop.Reason != debugger.Stepped {
+					break
+				}
+			}
+			printStop(w, d, sourcefile, stop)
+		case "rcontinue", "rc":
+			printStop(w, d, sourcefile, d.ReverseContinue())
+		case "registers", "r":
+			d.PrintRegisters(w)
+		case "rs":
+			d.PrintReturnStack(w)
+		case "stacks":
+			d.PrintStacks(w)
+		case "x":
+			if len(args) == 0 {
+				_, _ = fmt.Fprintf(w, "usage: x ADDR [N]\n")
+				break
+			}
+			address, err := d.Resolve(args[0])
+			if err != nil {
+				_, _ = fmt.Fprintf(w, "error: %v\n", err)
+				break
+			}
+			n := 1
+			if len(args) > 1 {
+				if n, _ = strconv.Atoi(args[1]); n < 1 {
+					n = 1
+				}
+			}
+			d.PrintMemory(w, address, n)
+		case "list", "l":
+			pc := d.VM().PC
+			if len(args) != 0 {
+				var err error
+				if pc, err = d.Resolve(args[0]); err != nil {
+					_, _ = fmt.Fprintf(w, "error: %v\n", err)
+					break
+				}
+			}
+			printSource(w, d, sourcefile, pc, 5)
+		case "snapshot":
+			if len(args) != 1 {
+				_, _ = fmt.Fprintf(w, "usage: snapshot FILE\n")
+			} else if err := writeSnapshot(args[0], d.VM()); err != nil {
+				_, _ = fmt.Fprintf(w, "error: %v\n", err)
+			} else {
+				_, _ = fmt.Fprintf(w, "saved %s\n", args[0])
+			}
+		case "record":
+			if len(args) != 1 {
+				_, _ = fmt.Fprintf(w, "usage: record FILE\n")
+			} else if err := writeRecording(args[0], d.Recording()); err != nil {
+				_, _ = fmt.Fprintf(w, "error: %v\n", err)
+			} else {
+				_, _ = fmt.Fprintf(w, "saved %s\n", args[0])
+			}
+		case "restart":
+			d.Start()
+			_, _ = fmt.Fprintf(w, "stopped at %s\n", d.Where())
+		case "quit", "q":
+			return nil
+		case "help", "h", "?":
+			_, _ = fmt.Fprint(w, debugHelp)
+		default:
+			_, _ = fmt.Fprintf(w, "unknown command %q (try help)\n", cmd)
+		}
+	}
+}
+
+// printStop reports why the machine stopped and shows the current source line.
+func printStop(w io.Writer, d *debugger.Debugger, sourcefile string, stop debugger.Stop) {
+	switch stop.Reason {
+	case debugger.Watchpoint:
+		for _, change := range stop.Changes {
+			_, _ = fmt.Fprintf(w, "watchpoint %s\n", change)
+		}
+	case debugger.Failed:
+		_, _ = fmt.Fprintf(w, "error: %v\n", stop.Err)
+	case debugger.Halted, debugger.Quit, debugger.Breakpoint:
+		_, _ = fmt.Fprintf(w, "%s\n", stop.Reason)
+	}
+	_, _ = fmt.Fprintf(w, "stopped at %s\n", d.Where())
+	printSource(w, d, sourcefile, d.VM().PC, 0)
+}
+
+// printSource prints the source lines around the instruction at pc.
+func printSource(w io.Writer, d *debugger.Debugger, sourcefile string, pc, context int) {
+	m := d.VM()
+	if pc < 0 || pc >= len(m.Core) || m.Core[pc].Source.Line == 0 {
+		return
+	}
+	current := m.Core[pc].Source.Line
+	for line := current - context; line <= current+context; line++ {
+		text, ok := sourceLine(sourcefile, line)
+		if !ok {
+			continue
+		}
+		marker := " "
+		if line == current {
+			marker = ">"
+		}
+		_, _ = fmt.Fprintf(w, "%s %6d |   %s\n", marker, line, text)
+	}
+}
@@ -0,0 +1,88 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package main
+
+import (
+	"fmt"
+	"github.com/maloquacious/ml_i/pkg/lowl/debugger"
+	"github.com/maloquacious/ml_i/pkg/lowl/vm"
+	"io"
+	"os"
+)
+
+// inspect implements "lasm inspect core [FILE]", which shows the
+// core file written when a run fails. FILE defaults to --core.
+func inspect(cfg *config) error {
+	if len(cfg.args) == 0 || cfg.args[0] != "core" || len(cfg.args) > 2 {
+		return fmt.Errorf("usage: lasm inspect core [FILE]")
+	}
+	name := cfg.core
+	if len(cfg.args) == 2 {
+		name = cfg.args[1]
+	}
+	if name == "" {
+		return fmt.Errorf("usage: lasm inspect core FILE (or set --core)")
+	}
+	fp, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = fp.Close() }()
+	core, err := vm.ReadCore(fp)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	printCore(os.Stdout, cfg.sourcefile, name, core)
+	return nil
+}
+
+// printCore writes the error, a backtrace, the registers, the memory
+// around the failing instruction and the stacks.
+func printCore(w io.Writer, sourcefile, name string, core *vm.CoreDump) {
+	m := core.Machine
+	// the debugger must not reset the machine
+	m.Registers.Suspended = true
+	d := debugger.New(m)
+
+	program := m.Name
+	if program == "" {
+		program = "main"
+	}
+	_, _ = fmt.Fprintf(w, "core file %s: program %s\n", name, program)
+	_, _ = fmt.Fprintf(w, "error: %s\n", core.Err)
+
+	_, _ = fmt.Fprintf(w, "\nbacktrace:\n")
+	for n, frame := range d.Frames() {
+		pc := frame.PC
+		if n == 0 {
+			pc = core.PC
+		}
+		_, _ = fmt.Fprintf(w, "#%-3d in %-12s %s\n", n, frame.Name, d.Describe(pc))
+		if pc < 0 || pc >= len(m.Core) || m.Core[pc].Source.Line == 0 {
+			continue
+		}
+		if text, ok := sourceLine(sourcefile, m.Core[pc].Source.Line); ok {
+			_, _ = fmt.Fprintf(w, "     %6d |   %s\n", m.Core[pc].Source.Line, text)
+		}
+	}
+
+	_, _ = fmt.Fprintf(w, "\nregisters:\n")
+	d.PrintRegisters(w)
+
+	_, _ = fmt.Fprintf(w, "\ncode around %d:\n", core.PC)
+	for pc := core.PC - 4; pc <= core.PC+4; pc++ {
+		if pc < 0 || pc >= len(m.Core) {
+			continue
+		}
+		marker := " "
+		if pc == core.PC {
+			marker = ">"
+		}
+		_, _ = fmt.Fprintf(w, "%s %s\n", marker, d.Describe(pc))
+	}
+
+	_, _ = fmt.Fprintf(w, "\nstacks:\n")
+	d.PrintStacks(w)
+}
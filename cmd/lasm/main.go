@@ -6,59 +6,379 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/maloquacious/ml_i/pkg/lowl/assembler"
 	"github.com/maloquacious/ml_i/pkg/lowl/ast"
 	"github.com/maloquacious/ml_i/pkg/lowl/cst"
+	"github.com/maloquacious/ml_i/pkg/lowl/vm"
+	"io"
 	"log"
 	"os"
 )
 
 func main() {
-	cfg, err := getConfig()
+	cfg, err := getConfig(os.Args[1:])
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	if err := run(cfg); err != nil {
-		fmt.Printf("\n\nerror:\n%v\n\n", err)
+	switch cfg.command {
+	case "run":
+		err = run(cfg)
+	case "debug":
+		err = debug(cfg)
+	case "dap":
+		err = serveDAP(cfg)
+	case "tracediff":
+		err = tracediff(cfg)
+	case "inspect":
+		err = inspect(cfg)
+	default:
+		err = fmt.Errorf("%s: unknown command", cfg.command)
+	}
+	if errors.Is(err, errTracesDiffer) {
+		os.Exit(1)
+	} else if err != nil {
+		var re *vm.RuntimeError
+		if errors.As(err, &re) {
+			fmt.Printf("\n\n")
+			report(os.Stdout, cfg.sourcefile, re)
+			if cerr := coreFileError(err); cerr != nil {
+				fmt.Printf("\nerror: %v\n", cerr)
+			} else if cfg.command == "run" && cfg.core != "" {
+				fmt.Printf("\ncore written to %s (see lasm inspect core %s)\n", cfg.core, cfg.core)
+			}
+			fmt.Printf("\n")
+		} else {
+			fmt.Printf("\n\nerror:\n%v\n\n", err)
+		}
 		log.Fatal("error")
 	}
 }
 
-func run(cfg *config) error {
-	parseTree, err := cst.Parse(cfg.sourcefile, false, cfg.test.scanner)
+// coreFileError returns the error from writing the core file,
+// which the machine joins to the runtime error, or nil.
+func coreFileError(err error) error {
+	var joined interface{ Unwrap() []error }
+	if errors.As(err, &joined) {
+		for _, e := range joined.Unwrap() {
+			if errors.Is(e, vm.ErrCoreFile) {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// assemble parses and assembles the source file with the options from the
+// config, and sets the entry point and the overflow handler if they are given.
+// It writes the listings and progress messages to w. When w is nil, it writes
+// nothing, since stdout may belong to the debug adapter or to a report.
+// It returns a nil machine if we are only testing the scanner.
+func assemble(cfg *config, sourcefile string, w io.Writer) (*vm.VM, error) {
+	parseTree, err := cst.Parse(sourcefile, false, cfg.test.scanner)
 	if cfg.test.scanner || err != nil {
-		return err
+		return nil, err
 	}
-	foundErrors := false
+	var firstError error
 	for _, node := range parseTree {
 		if node.Error != nil {
-			fmt.Printf("%d:%d %+v\n", node.Line, node.Col, node.Error)
-			foundErrors = true
+			if w != nil {
+				_, _ = fmt.Fprintf(w, "%d:%d %+v\n", node.Line, node.Col, node.Error)
+			}
+			if firstError == nil {
+				firstError = fmt.Errorf("%s:%d:%d: %w", sourcefile, node.Line, node.Col, node.Error)
+			}
 		}
 	}
-	if foundErrors == true {
-		return fmt.Errorf("found errors")
+	if firstError != nil {
+		return nil, firstError
 	}
 
 	syntaxTree, err := ast.Parse(parseTree)
 	if err != nil {
+		return nil, err
+	}
+	opts := assembler.Options{
+		Log:       w,
+		StackSize: cfg.stackSize,
+		WordSize:  cfg.wordSize,
+	}
+	if w != nil {
+		if err = syntaxTree.Listing("ast_listing.txt"); err != nil {
+			return nil, err
+		}
+		opts.Listing, opts.SymbolTable = "asm_listing.txt", "asm_symtab.txt"
+	}
+
+	m, err := assembler.AssembleWithOptions(syntaxTree, opts)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.entry != "" {
+		if err = m.SetEntry(cfg.entry); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.erlso != "" {
+		if err = m.SetOverflowHandler(cfg.erlso); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// openInput adds the input file, if any, to the machine's input streams.
+// The caller must call the returned function to close the file.
+func openInput(cfg *config, m *vm.VM) (func(), error) {
+	if cfg.inputfile == "" {
+		return func() {}, nil
+	}
+	fp, err := os.Open(cfg.inputfile)
+	if err != nil {
+		return nil, err
+	}
+	m.AddInput(cfg.inputfile, fp)
+	return func() { _ = fp.Close() }, nil
+}
+
+// openTrace starts tracing to the trace file, if any.
+// The caller must call the returned function to close the file.
+func openTrace(cfg *config, m *vm.VM) (func(), error) {
+	if cfg.trace.file == "" {
+		return func() {}, nil
+	}
+	format, err := vm.ParseTraceFormat(cfg.trace.format)
+	if err != nil {
+		return nil, err
+	}
+	fp, err := os.Create(cfg.trace.file)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(fp)
+	m.SetTrace(w, format)
+	return func() {
+		_ = w.Flush()
+		_ = fp.Close()
+	}, nil
+}
+
+// startProfile starts profiling if there is a profile file.
+// The caller must call the returned function to write the profile.
+func startProfile(cfg *config, m *vm.VM) (func() error, error) {
+	if cfg.profile.file == "" {
+		return func() error { return nil }, nil
+	} else if cfg.profile.format != "pprof" && cfg.profile.format != "text" {
+		return nil, fmt.Errorf("profile format %q: want pprof or text", cfg.profile.format)
+	}
+	p := vm.NewProfile()
+	p.SourceFile = cfg.sourcefile
+	m.SetProfile(p)
+	return func() error {
+		fp, err := os.Create(cfg.profile.file)
+		if err != nil {
+			return err
+		}
+		if cfg.profile.format == "text" {
+			err = p.WriteText(fp, 25)
+		} else {
+			err = p.WritePprof(fp)
+		}
+		if cerr := fp.Close(); err == nil {
+			err = cerr
+		}
 		return err
-	} else if err = syntaxTree.Listing("ast_listing.txt"); err != nil {
+	}, nil
+}
+
+// startCoverage starts collecting coverage if there is a coverage report.
+// The caller must call the returned function to write the reports.
+func startCoverage(cfg *config, m *vm.VM) func() error {
+	if cfg.coverage.listing == "" && cfg.coverage.html == "" {
+		return func() error { return nil }
+	}
+	c := vm.NewCoverage()
+	m.SetCoverage(c)
+	return func() error {
+		b := &bytes.Buffer{}
+		if cfg.coverage.listing != "" {
+			if err := c.WriteListing(b, m); err != nil {
+				return err
+			} else if err = os.WriteFile(cfg.coverage.listing, b.Bytes(), 0644); err != nil {
+				return err
+			}
+		}
+		if cfg.coverage.html != "" {
+			b.Reset()
+			if err := c.WriteHTML(b, m, cfg.sourcefile); err != nil {
+				return err
+			} else if err = os.WriteFile(cfg.coverage.html, b.Bytes(), 0644); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// restore loads the machine from the snapshot file. The input file, if
+// any, replaces the input stream that was current when the snapshot was
+// taken. If it is the same file, reading continues from the saved position.
+func restore(cfg *config) (*vm.VM, func(), error) {
+	fp, err := os.Open(cfg.snapshot.from)
+	if err != nil {
+		return nil, nil, err
+	}
+	m, err := vm.Restore(fp)
+	_ = fp.Close()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", cfg.snapshot.from, err)
+	}
+	if cfg.inputfile == "" {
+		return m, func() {}, nil
+	}
+	in, err := os.Open(cfg.inputfile)
+	if err != nil {
+		return nil, nil, err
+	}
+	name, offset := cfg.inputfile, 0
+	if n := m.Streams.Input; n < len(m.Streams.Inputs) {
+		name = m.Streams.Inputs[n].Name
+		if name == cfg.inputfile {
+			offset = m.Streams.Inputs[n].Offset
+		}
+	}
+	if offset != 0 {
+		if _, err = in.Seek(int64(offset), io.SeekStart); err != nil {
+			_ = in.Close()
+			return nil, nil, err
+		}
+	}
+	m.AddInputAt(name, in, offset)
+	return m, func() { _ = in.Close() }, nil
+}
+
+// writeSnapshot saves the machine to the snapshot file.
+func writeSnapshot(name string, m *vm.VM) error {
+	fp, err := os.Create(name)
+	if err != nil {
 		return err
 	}
+	err = m.Snapshot(fp)
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
 
-	vm, err := assembler.Assemble(syntaxTree)
+// replay loads the machine from the recording file. It reads the same
+// input as the recorded run, so no input file is opened.
+func replay(cfg *config) (*vm.VM, error) {
+	fp, err := os.Open(cfg.replay)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = fp.Close() }()
+	r, err := vm.ReadRecording(fp)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", cfg.replay, err)
+	}
+	return r.Replay()
+}
+
+// load returns the machine to run or debug. It is replayed from a
+// recording, restored from a snapshot, or assembled from the source.
+// The caller must call the returned function to close the input file.
+func load(cfg *config) (*vm.VM, func(), error) {
+	switch {
+	case cfg.replay != "":
+		m, err := replay(cfg)
+		return m, func() {}, err
+	case cfg.snapshot.from != "":
+		return restore(cfg)
+	}
+	m, err := assemble(cfg, cfg.sourcefile, os.Stdout)
+	if m == nil || err != nil {
+		return nil, nil, err
+	}
+	closeInput, err := openInput(cfg, m)
+	if err != nil {
+		return nil, nil, err
+	}
+	return m, closeInput, nil
+}
+
+// writeRecording saves the recording to the file.
+func writeRecording(name string, r *vm.Recording) error {
+	fp, err := os.Create(name)
 	if err != nil {
 		return err
 	}
+	_, err = r.WriteTo(fp)
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func run(cfg *config) error {
+	m, closeInput, err := load(cfg)
+	if m == nil || err != nil {
+		return err
+	}
+	defer closeInput()
+
+	closeTrace, err := openTrace(cfg, m)
+	if err != nil {
+		return err
+	}
+	defer closeTrace()
+
+	writeProfile, err := startProfile(cfg, m)
+	if err != nil {
+		return err
+	}
+
+	writeCoverage := startCoverage(cfg, m)
+
+	var recording *vm.Recording
+	if cfg.record != "" {
+		// replaying only needs the start and the input, so keep a single undo record
+		recording = vm.NewRecording()
+		recording.Limit = 1
+		m.SetRecording(recording)
+	}
 
 	stdout, stdmsg := &bytes.Buffer{}, &bytes.Buffer{}
-	err = vm.Run(stdout, stdmsg)
+	opts := vm.RunOptions{MaxSteps: cfg.maxSteps, CoreFile: cfg.core}
+	if cfg.timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+		defer cancel()
+		opts.Context = ctx
+	}
+	err = m.RunWithOptions(stdout, stdmsg, opts)
 	_ = os.WriteFile("vm_stdout.txt", stdout.Bytes(), 0644)
 	_ = os.WriteFile("vm_stdmsg.txt", stdmsg.Bytes(), 0644)
+	if perr := writeProfile(); perr != nil && err == nil {
+		err = perr
+	}
+	if cerr := writeCoverage(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if recording != nil {
+		if rerr := writeRecording(cfg.record, recording); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	if cfg.snapshot.to != "" {
+		if serr := writeSnapshot(cfg.snapshot.to, m); serr != nil && err == nil {
+			err = serr
+		}
+	}
 
 	return err
 }
@@ -0,0 +1,42 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/maloquacious/ml_i/pkg/lowl/vm"
+	"io"
+	"os"
+	"strings"
+)
+
+// report writes a readable description of a runtime error,
+// including the offending line from the source file.
+func report(w io.Writer, sourcefile string, e *vm.RuntimeError) {
+	_, _ = fmt.Fprintf(w, "runtime error: %v\n", e.Err)
+	_, _ = fmt.Fprintf(w, "  pc:        %d (%s %d %d)\n", e.PC, e.Word.Op, e.Word.Value, e.Word.ValueTwo)
+	if line := e.Word.Source.Line; line != 0 {
+		_, _ = fmt.Fprintf(w, "  source:    %s:%d: %s\n", sourcefile, line, strings.TrimSpace(e.Word.Source.Op.String()+" "+e.Word.Source.Parameters))
+		if text, ok := sourceLine(sourcefile, line); ok {
+			_, _ = fmt.Fprintf(w, "  %6d |   %s\n", line, text)
+		}
+	}
+	_, _ = fmt.Fprintf(w, "  registers: A %d  B %d  C %d  cmp %s\n", e.A, e.B, e.C, e.Cmp)
+	_, _ = fmt.Fprintf(w, "  return:    %v\n", e.RS)
+}
+
+// sourceLine returns the text of a line (starting with 1) from a file.
+func sourceLine(name string, line int) (string, bool) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return "", false
+	}
+	lines := bytes.Split(data, []byte{'\n'})
+	if line < 1 || line > len(lines) {
+		return "", false
+	}
+	return string(bytes.TrimRight(lines[line-1], "\r")), true
+}
@@ -0,0 +1,207 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/maloquacious/ml_i/pkg/lowl/vm"
+	"io"
+	"os"
+)
+
+var (
+	// errTracesDiffer is returned when the traces diverge.
+	// The report has already been printed, so main only sets the exit status.
+	errTracesDiffer = fmt.Errorf("traces differ")
+)
+
+// tracediff compares two execution traces and reports the first step
+// where they diverge. The traces are either two JSON trace files named
+// on the command line, or are created by running the source (and the
+// --against source) with the input (and the --against-input), within the
+// --max-steps and --timeout limits.
+func tracediff(cfg *config) error {
+	var left, right io.Reader
+	var leftName, rightName string
+	if len(cfg.args) == 2 {
+		leftName, rightName = cfg.args[0], cfg.args[1]
+		lf, err := os.Open(leftName)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = lf.Close() }()
+		rf, err := os.Open(rightName)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = rf.Close() }()
+		left, right = lf, rf
+	} else if len(cfg.args) == 0 && cfg.sourcefile != "" {
+		rightSource, rightInput := cfg.against.sourcefile, cfg.against.inputfile
+		if rightSource == "" {
+			rightSource = cfg.sourcefile
+		}
+		if rightInput == "" {
+			rightInput = cfg.inputfile
+		}
+		leftName, rightName = traceName(cfg.sourcefile, cfg.inputfile), traceName(rightSource, rightInput)
+		lb, err := traceProgram(cfg, cfg.sourcefile, cfg.inputfile)
+		if err != nil {
+			return err
+		}
+		rb, err := traceProgram(cfg, rightSource, rightInput)
+		if err != nil {
+			return err
+		}
+		left, right = lb, rb
+	} else {
+		return fmt.Errorf("tracediff: want two trace files or --source")
+	}
+
+	same, err := diffTraces(os.Stdout, leftName, rightName, left, right, cfg.context)
+	if err != nil {
+		return err
+	} else if !same {
+		return errTracesDiffer
+	}
+	return nil
+}
+
+// traceName describes a program run for the report.
+func traceName(sourcefile, inputfile string) string {
+	if inputfile == "" {
+		return sourcefile
+	}
+	return sourcefile + " < " + inputfile
+}
+
+// traceProgram runs the program with the input and returns its JSON trace.
+// It returns an error only if the program can't be assembled or the input
+// can't be opened. However the run ends, whether the program halts, quits,
+// fails, or runs out of steps or time, the trace shows how it got there,
+// and a failure is in the trace's error record, so the run's error is not
+// returned. That also keeps a runtime error in the --against program from
+// being reported against the --source file.
+func traceProgram(cfg *config, sourcefile, inputfile string) (*bytes.Buffer, error) {
+	m, err := assemble(cfg, sourcefile, nil)
+	if err != nil {
+		return nil, err
+	}
+	if inputfile != "" {
+		fp, err := os.Open(inputfile)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = fp.Close() }()
+		m.AddInput(inputfile, fp)
+	}
+
+	b := &bytes.Buffer{}
+	m.SetTrace(b, vm.TraceJSON)
+	opts := vm.RunOptions{MaxSteps: cfg.maxSteps}
+	if cfg.timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+		defer cancel()
+		opts.Context = ctx
+	}
+	_ = m.RunWithOptions(io.Discard, io.Discard, opts)
+	return b, nil
+}
+
+// traceReader reads the records of a JSON trace.
+type traceReader struct {
+	name    string
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newTraceReader(name string, r io.Reader) *traceReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	return &traceReader{name: name, scanner: scanner}
+}
+
+// next returns the next record. It returns false at the end of the trace.
+func (t *traceReader) next() (vm.TraceRecord, bool, error) {
+	var record vm.TraceRecord
+	for t.scanner.Scan() {
+		t.line++
+		if len(bytes.TrimSpace(t.scanner.Bytes())) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(t.scanner.Bytes(), &record); err != nil {
+			return record, false, fmt.Errorf("%s:%d: not a json trace record: %w", t.name, t.line, err)
+		}
+		return record, true, nil
+	}
+	return record, false, t.scanner.Err()
+}
+
+// diffTraces aligns the traces step by step and reports the first step
+// where they diverge, along with the steps that preceded it. It returns
+// true if the traces are the same.
+func diffTraces(w io.Writer, leftName, rightName string, left, right io.Reader, context int) (bool, error) {
+	lr, rr := newTraceReader(leftName, left), newTraceReader(rightName, right)
+	var history []vm.TraceRecord
+	for steps := 0; ; steps++ {
+		l, lok, err := lr.next()
+		if err != nil {
+			return false, err
+		}
+		r, rok, err := rr.next()
+		if err != nil {
+			return false, err
+		}
+
+		switch {
+		case !lok && !rok:
+			_, _ = fmt.Fprintf(w, "traces are the same (%d steps)\n", steps)
+			return true, nil
+		case !lok || !rok:
+			longer, shorter, next := rightName, leftName, r
+			if lok {
+				longer, shorter, next = leftName, rightName, l
+			}
+			_, _ = fmt.Fprintf(w, "traces diverge after step %d: %s ends, %s continues\n", steps, shorter, longer)
+			printContext(w, history)
+			_, _ = fmt.Fprintf(w, "next step in %s:\n  %s\n", longer, next)
+			return false, nil
+		}
+
+		if diffs := l.Diff(r); len(diffs) != 0 {
+			_, _ = fmt.Fprintf(w, "traces diverge at step %d\n", steps+1)
+			printContext(w, history)
+			_, _ = fmt.Fprintf(w, "%s:\n  %s\n", leftName, l)
+			_, _ = fmt.Fprintf(w, "%s:\n  %s\n", rightName, r)
+			_, _ = fmt.Fprintf(w, "differences:\n")
+			for _, diff := range diffs {
+				_, _ = fmt.Fprintf(w, "  %s\n", diff)
+			}
+			return false, nil
+		}
+
+		if context > 0 {
+			if len(history) == context {
+				history = history[1:]
+			}
+			history = append(history, l)
+		}
+	}
+}
+
+// printContext prints the steps that both traces agree on.
+func printContext(w io.Writer, history []vm.TraceRecord) {
+	if len(history) == 0 {
+		return
+	}
+	_, _ = fmt.Fprintf(w, "preceding steps:\n")
+	for _, record := range history {
+		_, _ = fmt.Fprintf(w, "  %s\n", record)
+	}
+}
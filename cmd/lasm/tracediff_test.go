@@ -0,0 +1,133 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestDiffTraces tests aligning two JSON traces and reporting where they diverge.
+func TestDiffTraces(t *testing.T) {
+	const (
+		step1 = `{"step":1,"pc":0,"op":"LAL","value":7,"value2":0,"before":{"a":0},"after":{"a":7}}`
+		step2 = `{"step":2,"pc":1,"op":"AAL","value":1,"value2":0,"before":{"a":7},"after":{"a":8}}`
+		step3 = `{"step":3,"pc":2,"op":"HALT","value":0,"value2":0,"before":{"a":8},"after":{"a":8}}`
+		other = `{"step":2,"pc":1,"op":"AAL","value":2,"value2":0,"before":{"a":7},"after":{"a":9}}`
+	)
+	trace := func(records ...string) string {
+		return strings.Join(records, "\n") + "\n"
+	}
+
+	for _, tc := range []struct {
+		id          int
+		left, right string
+		context     int
+		same        bool
+		want        []string // lines expected in the report
+	}{
+		{id: 1, left: trace(step1, step2, step3), right: trace(step1, step2, step3), same: true,
+			want: []string{"traces are the same (3 steps)"}},
+		{id: 2, left: "", right: "\n", same: true,
+			want: []string{"traces are the same (0 steps)"}},
+		{id: 3, left: trace(step1, step2, step3), right: trace(step1, other, step3), context: 1,
+			want: []string{"traces diverge at step 2", "preceding steps:", "value: 1 != 2", "after.A: 8 != 9"}},
+		{id: 4, left: trace(step1, other), right: trace(step1, step2, step3),
+			want: []string{"traces diverge at step 2", "value: 2 != 1"}},
+		{id: 5, left: trace(step1, step2), right: trace(step1, step2, step3), context: 2,
+			want: []string{"traces diverge after step 2: left ends, right continues", "preceding steps:", "next step in right:"}},
+		{id: 6, left: trace(step1, step2, step3), right: trace(step1),
+			want: []string{"traces diverge after step 1: right ends, left continues", "next step in left:"}},
+	} {
+		w := &bytes.Buffer{}
+		same, err := diffTraces(w, "left", "right", strings.NewReader(tc.left), strings.NewReader(tc.right), tc.context)
+		if err != nil {
+			t.Errorf("%d: want nil: got %v\n", tc.id, err)
+			continue
+		}
+		if same != tc.same {
+			t.Errorf("%d: same: want %v: got %v\n", tc.id, tc.same, same)
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(w.String(), want) {
+				t.Errorf("%d: report: want %q: got\n%s\n", tc.id, want, w.String())
+			}
+		}
+		if tc.context == 0 && strings.Contains(w.String(), "preceding steps:") {
+			t.Errorf("%d: report: want no context: got\n%s\n", tc.id, w.String())
+		}
+	}
+
+	// a line that is not a trace record is an error, not a difference
+	if _, err := diffTraces(&bytes.Buffer{}, "left", "right", strings.NewReader(trace(step1, "step 2")), strings.NewReader(trace(step1, step2)), 0); err == nil || !strings.Contains(err.Error(), "left:2: not a json trace record") {
+		t.Errorf("bad record: want left:2 error: got %v\n", err)
+	}
+}
+
+// TestTraceProgram tests that a program that halts or fails at runtime
+// is traced to the end instead of being reported as an error.
+func TestTraceProgram(t *testing.T) {
+	dir := t.TempDir()
+	source := func(name, instruction string) string {
+		path := filepath.Join(dir, name)
+		text := strings.Join([]string{
+			"        PRGST 'TEST'",
+			"        DCL   FFPT",
+			"        DCL   LFPT",
+			"        DCL   PARNM",
+			"[BEGIN] " + instruction,
+			"        PRGEN",
+			"",
+		}, "\n")
+		if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	seven, eight, bad := source("seven.lowl", "LAL   7"), source("eight.lowl", "LAL   8"), source("bad.lowl", "CSS")
+
+	for _, tc := range []struct {
+		id          int
+		left, right string
+		want        []string // lines expected in the report
+	}{
+		{id: 1, left: seven, right: eight,
+			want: []string{"traces diverge at step 1", "value: 7 != 8"}},
+		{id: 2, left: seven, right: bad,
+			want: []string{"traces diverge at step 1", "op: LAL != CSS", "return stack underflow"}},
+	} {
+		lb, err := traceProgram(&config{}, tc.left, "")
+		if err != nil {
+			t.Errorf("%d: left: want nil: got %v\n", tc.id, err)
+			continue
+		}
+		rb, err := traceProgram(&config{}, tc.right, "")
+		if err != nil {
+			t.Errorf("%d: right: want nil: got %v\n", tc.id, err)
+			continue
+		}
+		w := &bytes.Buffer{}
+		same, err := diffTraces(w, tc.left, tc.right, lb, rb, 0)
+		if err != nil {
+			t.Errorf("%d: diff: want nil: got %v\n", tc.id, err)
+			continue
+		} else if same {
+			t.Errorf("%d: same: want false: got true\n", tc.id)
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(w.String(), want) {
+				t.Errorf("%d: report: want %q: got\n%s\n", tc.id, want, w.String())
+			}
+		}
+	}
+
+	// a source that can't be assembled is still an error
+	if _, err := traceProgram(&config{}, filepath.Join(dir, "missing.lowl"), ""); err == nil {
+		t.Errorf("missing: want error: got nil\n")
+	}
+}
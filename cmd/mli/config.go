@@ -0,0 +1,61 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/peterbourgon/ff/v3"
+	"os"
+	"time"
+)
+
+type config struct {
+	version   string
+	debug     bool
+	native    bool
+	logicfile string
+	sources   []string
+	maxSteps  int
+	timeout   time.Duration
+	core      string // write the machine to this file when the run fails
+	trace     struct {
+		file   string
+		format string
+	}
+}
+
+func getConfig() (*config, error) {
+	// create the config structure with default values
+	cfg := &config{
+		version:  "L4A",
+		maxSteps: -1,
+	}
+
+	// create a flag set and then parse the command line (and optional configuration file)
+	fs := flag.NewFlagSet("mli", flag.ContinueOnError)
+	var (
+		_ = fs.String("config", "", "config file (optional, json)")
+	)
+	fs.StringVar(&cfg.logicfile, "logic", cfg.logicfile, "ML/I LOWL logic file (required unless --native)")
+	fs.BoolVar(&cfg.native, "native", cfg.native, "use the native Go engine instead of the logic (optional)")
+	fs.IntVar(&cfg.maxSteps, "max-steps", cfg.maxSteps, "maximum steps to run the logic, -1 for unlimited (optional)")
+	fs.DurationVar(&cfg.timeout, "timeout", cfg.timeout, "maximum time to run the logic (optional)")
+	fs.StringVar(&cfg.core, "core", cfg.core, "write the machine to this file when the logic fails (optional)")
+	fs.StringVar(&cfg.trace.file, "trace", cfg.trace.file, "write an execution trace to this file (optional)")
+	fs.StringVar(&cfg.trace.format, "trace-format", "json", "format of the execution trace, json or text (optional)")
+	fs.BoolVar(&cfg.debug, "debug", cfg.debug, "log debug information (optional)")
+	if err := ff.Parse(fs, os.Args[1:], ff.WithEnvVarPrefix("MLI"), ff.WithConfigFileFlag("config"), ff.WithConfigFileParser(ff.JSONParser), ff.WithIgnoreUndefined(false)); err != nil {
+		return nil, err
+	} else if cfg.logicfile == "" && !cfg.native {
+		return nil, fmt.Errorf("--logic is required")
+	}
+
+	// any remaining arguments are source files. if there are none,
+	// the source text is read from stdin.
+	cfg.sources = fs.Args()
+
+	return cfg, nil
+}
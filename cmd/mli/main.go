@@ -0,0 +1,123 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+// Package main implements the ML/I macro processor.
+// It assembles the ML/I logic and then runs it on the LOWL virtual machine,
+// reading source text from files (or stdin) and writing the result to stdout.
+// With --native, the source text is processed by the Go engine in pkg/mli.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/maloquacious/ml_i/pkg/lowl/assembler"
+	"github.com/maloquacious/ml_i/pkg/lowl/ast"
+	"github.com/maloquacious/ml_i/pkg/lowl/cst"
+	"github.com/maloquacious/ml_i/pkg/lowl/vm"
+	"github.com/maloquacious/ml_i/pkg/mli"
+	"io"
+	"log"
+	"os"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("mli: ")
+
+	cfg, err := getConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := run(cfg); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(cfg *config) error {
+	// open the source files. if there are none, read from stdin.
+	var sources []io.Reader
+	for _, name := range cfg.sources {
+		fp, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		sources = append(sources, fp)
+	}
+	if len(sources) == 0 {
+		sources = append(sources, os.Stdin)
+	}
+	input := io.MultiReader(sources...)
+
+	stdout := bufio.NewWriter(os.Stdout)
+	defer stdout.Flush()
+
+	if cfg.native {
+		e := mli.New()
+		e.Messages = os.Stderr
+		return e.Process(input, stdout)
+	}
+
+	m, err := assemble(cfg)
+	if err != nil {
+		return err
+	}
+	m.SetInput(input)
+	if cfg.trace.file != "" {
+		format, err := vm.ParseTraceFormat(cfg.trace.format)
+		if err != nil {
+			return err
+		}
+		fp, err := os.Create(cfg.trace.file)
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		trace := bufio.NewWriter(fp)
+		defer trace.Flush()
+		m.SetTrace(trace, format)
+	}
+
+	// run the logic until it asks to quit, fails, or runs out of budget.
+	opts := vm.RunOptions{MaxSteps: cfg.maxSteps, CoreFile: cfg.core}
+	if cfg.timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+		defer cancel()
+		opts.Context = ctx
+	}
+	if err := m.RunWithOptions(stdout, os.Stderr, opts); errors.Is(err, vm.ErrHalted) {
+		return fmt.Errorf("logic halted at %d", m.PC)
+	} else if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// assemble parses the ML/I logic and returns a machine ready to run it.
+func assemble(cfg *config) (*vm.VM, error) {
+	parseTree, err := cst.Parse(cfg.logicfile, false, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range parseTree {
+		if node.Error != nil {
+			return nil, fmt.Errorf("%s:%d:%d: %w", cfg.logicfile, node.Line, node.Col, node.Error)
+		}
+	}
+
+	syntaxTree, err := ast.Parse(parseTree)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := assembler.Options{}
+	if cfg.debug {
+		opts.Listing, opts.SymbolTable, opts.Log = "asm_listing.txt", "asm_symtab.txt", os.Stderr
+	}
+	return assembler.AssembleWithOptions(syntaxTree, opts)
+}
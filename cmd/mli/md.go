@@ -0,0 +1,47 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package main
+
+import (
+	"github.com/maloquacious/ml_i/pkg/lowl/vm"
+	"io"
+	"strconv"
+)
+
+// the machine-dependent subroutines that the ML/I logic needs in
+// addition to the ones provided by the virtual machine.
+func init() {
+	vm.RegisterMD("MDCONV", mdConv)
+}
+
+// mdConv converts register A to decimal characters on the forwards stack.
+// It returns the number of characters stacked in register A.
+// Each digit is stacked just as CFSTK does: the digit is stored, FFPT is
+// bumped by LCH, and if FFPT has reached LFPT, it branches to ERLSO.
+// On overflow, register A holds the number of characters stacked before
+// the branch.
+func mdConv(m *vm.VM, stdout, stderr io.Writer) (int, error) {
+	text := strconv.Itoa(m.A)
+	for n, ch := range text {
+		// same as CFSTK, but with the digit rather than register C
+		ffpt, err := m.Load(m.Registers.FFPT)
+		if err != nil {
+			return 0, err
+		} else if err = m.Push(vm.ForwardsStack, ffpt, int(ch)); err != nil {
+			return 0, err
+		} else if err = m.Store(m.Registers.FFPT, ffpt+m.Registers.LCH); err != nil {
+			return 0, err
+		}
+		lfpt, err := m.Load(m.Registers.LFPT)
+		if err != nil {
+			return 0, err
+		} else if m.Unsigned(ffpt+m.Registers.LCH) >= m.Unsigned(lfpt) { // ERLSO
+			m.A = n + 1
+			return 1, m.StackOverflow("MDCONV")
+		}
+	}
+	m.A = len(text)
+	return 1, nil
+}
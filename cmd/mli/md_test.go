@@ -0,0 +1,103 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package main
+
+import (
+	"errors"
+	"github.com/maloquacious/ml_i/pkg/lowl/op"
+	"github.com/maloquacious/ml_i/pkg/lowl/vm"
+	"testing"
+)
+
+// TestMDConv tests converting register A to digits on the forwards stack.
+func TestMDConv(t *testing.T) {
+	if _, ok := vm.LookupMD("MDCONV"); !ok {
+		t.Fatalf("MDCONV: not registered\n")
+	}
+
+	// newvm returns a machine that calls MDCONV with a stack region of
+	// Core[20:30], FFPT in address 1 and LFPT in address 2.
+	newvm := func(a, ffpt, lfpt int) *vm.VM {
+		m := &vm.VM{A: a}
+		m.Registers.FFPT, m.Registers.LFPT, m.Registers.LCH, m.Registers.LNM = 1, 2, 1, 1
+		m.Registers.StackStart, m.Registers.StackEnd = 20, 30
+		m.SetWord(1, vm.Word{Value: ffpt})
+		m.SetWord(2, vm.Word{Value: lfpt})
+		m.SetWord(0, vm.Word{Op: op.MDCALL, Text: "MDCONV"})
+		return m
+	}
+	stacked := func(m *vm.VM, from, to int) string {
+		var b []byte
+		for address := from; address < to; address++ {
+			b = append(b, byte(m.Core[address].Value))
+		}
+		return string(b)
+	}
+
+	for _, tc := range []struct {
+		a    int
+		want string
+	}{
+		{0, "0"},
+		{1234, "1234"},
+		{-56, "-56"},
+	} {
+		m := newvm(tc.a, 20, 30)
+		if err := m.Step(nil, nil); err != nil {
+			t.Fatalf("%d: want nil: got %v\n", tc.a, err)
+		}
+		if got := stacked(m, 20, m.Core[1].Value); got != tc.want {
+			t.Errorf("%d: stack: want %q: got %q\n", tc.a, tc.want, got)
+		}
+		if m.A != len(tc.want) || m.Registers.JumpValue != 1 || m.PC != 1 {
+			t.Errorf("%d: want A %d exit 1 pc 1: got A %d exit %d pc %d\n", tc.a, len(tc.want), m.A, m.Registers.JumpValue, m.PC)
+		}
+	}
+
+	// each digit is reported to the OnPush hook
+	m := newvm(42, 20, 30)
+	var pushes []int
+	m.Hooks = &vm.Hooks{OnPush: func(m *vm.VM, stack vm.StackKind, address, value int) {
+		if stack == vm.ForwardsStack {
+			pushes = append(pushes, address, value)
+		}
+	}}
+	if err := m.Step(nil, nil); err != nil {
+		t.Fatalf("push: want nil: got %v\n", err)
+	} else if len(pushes) != 4 || pushes[0] != 20 || pushes[1] != '4' || pushes[2] != 21 || pushes[3] != '2' {
+		t.Errorf("push: want [20 %d 21 %d]: got %v\n", '4', '2', pushes)
+	}
+
+	// as with CFSTK, the third digit is stacked and FFPT bumped to LFPT
+	// before the collision is detected; nothing is stacked past LFPT
+	m = newvm(1234, 20, 23)
+	if err := m.Step(nil, nil); !errors.Is(err, vm.ErrStackOverflow) {
+		t.Errorf("collision: want stack overflow: got %v\n", err)
+	}
+	if got := stacked(m, 20, 24); got != "123\x00" || m.Core[1].Value != 23 {
+		t.Errorf("collision: want %q ffpt 23: got %q ffpt %d\n", "123\x00", got, m.Core[1].Value)
+	}
+	if m.A != 3 {
+		t.Errorf("collision: a: want 3: got %d\n", m.A)
+	}
+
+	// with a handler, the collision branches to ERLSO
+	m = newvm(1234, 20, 23)
+	m.Registers.ERLSO = 9
+	if err := m.Step(nil, nil); err != nil {
+		t.Errorf("erlso: want nil: got %v\n", err)
+	} else if m.PC != 9 || m.A != 3 {
+		t.Errorf("erlso: want pc 9 a 3: got pc %d a %d\n", m.PC, m.A)
+	}
+
+	// with 16-bit words, addresses above the sign boundary compare unsigned
+	m = newvm(7, 40000-65536, 40010-65536)
+	_ = m.SetWordSize(16)
+	if err := m.Step(nil, nil); err != nil {
+		t.Errorf("unsigned: want nil: got %v\n", err)
+	} else if m.Core[40000].Value != '7' {
+		t.Errorf("unsigned: want %d: got %d\n", '7', m.Core[40000].Value)
+	}
+}
@@ -0,0 +1,38 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package gpm
+
+import "fmt"
+
+var (
+	ErrBadNumber       = fmt.Errorf("invalid number")
+	ErrBadOperator     = fmt.Errorf("invalid operator")
+	ErrBadParameter    = fmt.Errorf("invalid parameter")
+	ErrMissingArgument = fmt.Errorf("missing argument")
+	ErrTooDeep         = fmt.Errorf("macro calls nested too deeply")
+	ErrUndefinedMacro  = fmt.Errorf("undefined macro")
+	ErrUnterminated    = fmt.Errorf("unexpected end of input")
+	ErrUpdateTooLong   = fmt.Errorf("update is longer than the definition")
+)
+
+// Error is returned by Process when the input can not be processed.
+type Error struct {
+	Line  int    // line of the input where the error was detected
+	Macro string // name of the macro being called, if any
+	Err   error  // the underlying error
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	if e.Macro == "" {
+		return fmt.Sprintf("gpm: %d: %v", e.Line, e.Err)
+	}
+	return fmt.Sprintf("gpm: %d: %s: %v", e.Line, e.Macro, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
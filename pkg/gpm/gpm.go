@@ -0,0 +1,236 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+// Package gpm implements Strachey's General Purpose Macrogenerator.
+//
+// A macro call is written "$NAME,arg1,arg2;". The name and arguments are
+// evaluated as they are read, so calls may be nested anywhere. Text in
+// quotes "<...>" is copied without evaluation and one level of quotes is
+// removed. Inside a macro body, "~n" is replaced by argument n ("~0" is
+// the name). Definitions made while a call is being collected or evaluated
+// are discarded when the call ends.
+//
+// The machine macros are
+//
+//	$DEF,name,body;        define a macro
+//	$VAL,name;             the body of a macro, unevaluated
+//	$UPDATE,name,body;     replace the body of a macro (it may not grow)
+//	$BIN,decimal;          convert a decimal number to a binary word
+//	$DEC,word;             convert a binary word to a decimal number
+//	$BAR,op,word,word;     binary arithmetic, op is one of + - * / R
+//
+// As in the original, text is a sequence of words. A binary number is a
+// single word, so BIN, DEC and BAR are normally used together.
+package gpm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// Markers are the warning characters that GPM treats specially.
+type Markers struct {
+	Call       rune // starts a macro call
+	Separator  rune // separates the name and arguments
+	End        rune // ends a macro call
+	Param      rune // followed by a digit, inserts an argument
+	QuoteOpen  rune // starts quoted text
+	QuoteClose rune // ends quoted text
+}
+
+// DefaultMarkers are the characters used in Strachey's paper,
+// with $ standing in for the section sign.
+var DefaultMarkers = Markers{
+	Call:       '$',
+	Separator:  ',',
+	End:        ';',
+	Param:      '~',
+	QuoteOpen:  '<',
+	QuoteClose: '>',
+}
+
+// Processor is a General Purpose Macrogenerator.
+type Processor struct {
+	Markers  Markers
+	MaxDepth int // maximum depth of nested macro calls
+
+	env  *definition // most recent definition
+	line int         // current line of the input
+}
+
+// definition is an entry in the environment chain.
+type definition struct {
+	name    string
+	body    []int
+	machine func(p *Processor, items [][]int, out sink) error
+	defines bool // true if the machine macro adds to the environment
+	next    *definition
+}
+
+// New returns a processor with the default markers and the machine macros defined.
+func New() *Processor {
+	p := &Processor{
+		Markers:  DefaultMarkers,
+		MaxDepth: 250,
+	}
+	for _, m := range []struct {
+		name    string
+		machine func(p *Processor, items [][]int, out sink) error
+	}{
+		{"DEF", def},
+		{"VAL", val},
+		{"UPDATE", update},
+		{"BIN", bin},
+		{"DEC", dec},
+		{"BAR", bar},
+	} {
+		p.env = &definition{name: m.name, machine: m.machine, defines: m.name == "DEF", next: p.env}
+	}
+	return p
+}
+
+// Process reads the input, evaluates macro calls, and writes the result.
+// Errors are returned as *Error.
+func (p *Processor) Process(r io.Reader, w io.Writer) error {
+	p.line = 1
+	in := &input{r: bufio.NewReader(r), line: &p.line}
+	bw := bufio.NewWriter(w)
+	err := p.scan(in, nil, &writer{w: bw}, 0)
+	if ferr := bw.Flush(); ferr != nil && err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// lookup returns the most recent definition of the name.
+func (p *Processor) lookup(name string) *definition {
+	for d := p.env; d != nil; d = d.next {
+		if d.name == name {
+			return d
+		}
+	}
+	return nil
+}
+
+// scan copies src to out, evaluating macro calls, until src is exhausted.
+// args are the items of the call whose body is being read, if any.
+func (p *Processor) scan(src source, args [][]int, out sink, depth int) error {
+	for {
+		ch, ok := src.next()
+		if !ok {
+			return nil
+		}
+		var err error
+		switch ch {
+		case int(p.Markers.QuoteOpen):
+			err = p.quote(src, out)
+		case int(p.Markers.Call):
+			err = p.call(src, args, out, depth)
+		case int(p.Markers.Param):
+			err = p.param(src, args, out)
+		default:
+			out.put(ch)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// quote copies quoted text, removing the outer quotes.
+func (p *Processor) quote(src source, out sink) error {
+	for level := 1; ; {
+		ch, ok := src.next()
+		if !ok {
+			return &Error{Line: p.line, Err: fmt.Errorf("quoted text: %w", ErrUnterminated)}
+		}
+		switch ch {
+		case int(p.Markers.QuoteOpen):
+			level++
+		case int(p.Markers.QuoteClose):
+			if level--; level == 0 {
+				return nil
+			}
+		}
+		out.put(ch)
+	}
+}
+
+// param copies the argument named by the digit following the marker.
+func (p *Processor) param(src source, args [][]int, out sink) error {
+	ch, ok := src.next()
+	if !ok {
+		return &Error{Line: p.line, Err: fmt.Errorf("parameter: %w", ErrUnterminated)}
+	} else if ch < '0' || ch > '9' {
+		return &Error{Line: p.line, Err: fmt.Errorf("%q: %w", rune(ch), ErrBadParameter)}
+	} else if args == nil {
+		return &Error{Line: p.line, Err: fmt.Errorf("%q: outside a call: %w", rune(ch), ErrBadParameter)}
+	} else if n := ch - '0'; n >= len(args) {
+		return &Error{Line: p.line, Macro: string(runes(args[0])), Err: fmt.Errorf("%d: %w", n, ErrMissingArgument)}
+	} else {
+		for _, w := range args[n] {
+			out.put(w)
+		}
+	}
+	return nil
+}
+
+// call collects the name and arguments of a call and then applies it.
+func (p *Processor) call(src source, args [][]int, out sink, depth int) error {
+	if depth >= p.MaxDepth {
+		return &Error{Line: p.line, Err: fmt.Errorf("depth %d: %w", depth, ErrTooDeep)}
+	}
+	saved := p.env
+	var items [][]int
+	item := &buffer{}
+	for {
+		ch, ok := src.next()
+		if !ok {
+			return &Error{Line: p.line, Err: fmt.Errorf("call: %w", ErrUnterminated)}
+		}
+		var err error
+		switch ch {
+		case int(p.Markers.QuoteOpen):
+			err = p.quote(src, item)
+		case int(p.Markers.Call):
+			err = p.call(src, args, item, depth+1)
+		case int(p.Markers.Param):
+			err = p.param(src, args, item)
+		case int(p.Markers.Separator):
+			items, item = append(items, item.words), &buffer{}
+		case int(p.Markers.End):
+			items = append(items, item.words)
+			return p.apply(items, out, depth, saved)
+		default:
+			item.put(ch)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// apply evaluates a call. Definitions made while the call was collected
+// or evaluated are discarded when it ends, except those made by DEF itself.
+func (p *Processor) apply(items [][]int, out sink, depth int, saved *definition) error {
+	name := string(runes(items[0]))
+	d := p.lookup(name)
+	if d == nil {
+		return &Error{Line: p.line, Macro: name, Err: ErrUndefinedMacro}
+	}
+	if d.machine != nil {
+		err := d.machine(p, items, out)
+		if !d.defines {
+			p.env = saved
+		}
+		if err != nil {
+			return &Error{Line: p.line, Macro: name, Err: err}
+		}
+		return nil
+	}
+	err := p.scan(&body{words: d.body}, items, out, depth+1)
+	p.env = saved
+	return err
+}
@@ -0,0 +1,77 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package gpm_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/maloquacious/ml_i/pkg/gpm"
+	"strings"
+	"testing"
+)
+
+func TestProcess(t *testing.T) {
+	for _, tc := range []struct {
+		id          int
+		input, want string
+	}{
+		{id: 1, input: "plain text, no calls; really\n", want: "plain text, no calls; really\n"},
+		{id: 2, input: "$DEF,A,<x~1y>;$A,1;", want: "x1y"},
+		{id: 3, input: "$DEF,A,<~0(~2,~1)>;$A,p,q;", want: "A(q,p)"},
+		{id: 4, input: "<$A,<b>;>", want: "$A,<b>;"},
+		{id: 5, input: "$DEF,SUC,<$DEC,$BAR,+,$BIN,~1;,$BIN,1;;;>;$SUC,41;", want: "42"},
+		{id: 6, input: "$DEC,$BAR,R,$BIN,17;,$BIN,5;;;/$DEC,$BIN,-3;;", want: "2/-3"},
+		{id: 7, input: "$DEF,X,<abc>;$VAL,X;|$UPDATE,X,<z>;$X;", want: "abc|z"},
+		{id: 8, input: "$DEF,F,<$DEF,G,<in>;$G;>;$F;", want: "in"},
+		{id: 9, input: "$DEF,$DEF,N,<A>;$N;,<nested>;$A;", want: "nested"},
+		{id: 10, input: "$DEF,T,<~1>;$DEF,F,<~2>;$DEF,IF,<$~1,~2,~3;>;$IF,T,yes,no;$IF,F,yes,no;", want: "yesno"},
+	} {
+		p := gpm.New()
+		out := &bytes.Buffer{}
+		if err := p.Process(strings.NewReader(tc.input), out); err != nil {
+			t.Errorf("%d: want nil: got %v\n", tc.id, err)
+			continue
+		}
+		if got := out.String(); got != tc.want {
+			t.Errorf("%d: want %q: got %q\n", tc.id, tc.want, got)
+		}
+	}
+}
+
+func TestMarkers(t *testing.T) {
+	p := gpm.New()
+	p.Markers = gpm.Markers{Call: '#', Separator: '|', End: '.', Param: '@', QuoteOpen: '[', QuoteClose: ']'}
+	out := &bytes.Buffer{}
+	if err := p.Process(strings.NewReader("#DEF|HI|[hi @1, $0;].#HI|bob."), out); err != nil {
+		t.Fatalf("want nil: got %v\n", err)
+	} else if got, want := out.String(), "hi bob, $0;"; got != want {
+		t.Errorf("want %q: got %q\n", want, got)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, tc := range []struct {
+		id    int
+		input string
+		want  error
+	}{
+		{id: 1, input: "$NOPE;", want: gpm.ErrUndefinedMacro},
+		{id: 2, input: "$DEF,F,<$DEF,G,<in>;>;$F;$G;", want: gpm.ErrUndefinedMacro},
+		{id: 3, input: "$DEF,A,x", want: gpm.ErrUnterminated},
+		{id: 4, input: "$DEF,X,<a>;$UPDATE,X,<abc>;", want: gpm.ErrUpdateTooLong},
+		{id: 5, input: "$DEF,A,<~2>;$A,1;", want: gpm.ErrMissingArgument},
+		{id: 6, input: "$DEF,L,<$L;>;$L;", want: gpm.ErrTooDeep},
+		{id: 7, input: "$BAR,/,$BIN,1;,$BIN,0;;", want: gpm.ErrBadNumber},
+	} {
+		p := gpm.New()
+		err := p.Process(strings.NewReader(tc.input), &bytes.Buffer{})
+		var ge *gpm.Error
+		if !errors.As(err, &ge) {
+			t.Errorf("%d: want *gpm.Error: got %v\n", tc.id, err)
+		} else if !errors.Is(err, tc.want) {
+			t.Errorf("%d: want %v: got %v\n", tc.id, tc.want, err)
+		}
+	}
+}
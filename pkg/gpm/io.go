@@ -0,0 +1,76 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package gpm
+
+import "bufio"
+
+// source is a stream of words to be scanned.
+type source interface {
+	next() (int, bool)
+}
+
+// input reads words (characters) from the input stream.
+type input struct {
+	r    *bufio.Reader
+	line *int
+}
+
+func (in *input) next() (int, bool) {
+	ch, _, err := in.r.ReadRune()
+	if err != nil {
+		return 0, false
+	}
+	if ch == '\n' {
+		*in.line = *in.line + 1
+	}
+	return int(ch), true
+}
+
+// body reads words from the body of a definition.
+type body struct {
+	words []int
+	pos   int
+}
+
+func (b *body) next() (int, bool) {
+	if b.pos >= len(b.words) {
+		return 0, false
+	}
+	w := b.words[b.pos]
+	b.pos++
+	return w, true
+}
+
+// sink receives the words produced by scanning.
+type sink interface {
+	put(w int)
+}
+
+// buffer collects words for an item of a call.
+type buffer struct {
+	words []int
+}
+
+func (b *buffer) put(w int) {
+	b.words = append(b.words, w)
+}
+
+// writer writes words to the output stream as characters.
+type writer struct {
+	w *bufio.Writer
+}
+
+func (w *writer) put(word int) {
+	_, _ = w.w.WriteRune(rune(word))
+}
+
+// runes converts words to runes.
+func runes(words []int) []rune {
+	r := make([]rune, len(words))
+	for i, w := range words {
+		r[i] = rune(w)
+	}
+	return r
+}
@@ -0,0 +1,144 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package gpm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// arg returns item n or an error if the call does not have that many items.
+func arg(items [][]int, n int) ([]int, error) {
+	if n >= len(items) {
+		return nil, fmt.Errorf("%d: %w", n, ErrMissingArgument)
+	}
+	return items[n], nil
+}
+
+// word returns item n, which must be a single (binary) word.
+func word(items [][]int, n int) (int, error) {
+	w, err := arg(items, n)
+	if err != nil {
+		return 0, err
+	} else if len(w) != 1 {
+		return 0, fmt.Errorf("%q: want binary word: %w", string(runes(w)), ErrBadNumber)
+	}
+	return w[0], nil
+}
+
+// def adds a definition to the environment.
+func def(p *Processor, items [][]int, out sink) error {
+	name, err := arg(items, 1)
+	if err != nil {
+		return err
+	}
+	body, err := arg(items, 2)
+	if err != nil {
+		return err
+	}
+	p.env = &definition{name: string(runes(name)), body: body, next: p.env}
+	return nil
+}
+
+// val copies the body of a definition without evaluating it.
+func val(p *Processor, items [][]int, out sink) error {
+	name, err := arg(items, 1)
+	if err != nil {
+		return err
+	}
+	d := p.lookup(string(runes(name)))
+	if d == nil || d.machine != nil {
+		return fmt.Errorf("%q: %w", string(runes(name)), ErrUndefinedMacro)
+	}
+	for _, w := range d.body {
+		out.put(w)
+	}
+	return nil
+}
+
+// update replaces the body of a definition.
+// As in the original, the new body may not be longer than the old one.
+func update(p *Processor, items [][]int, out sink) error {
+	name, err := arg(items, 1)
+	if err != nil {
+		return err
+	}
+	body, err := arg(items, 2)
+	if err != nil {
+		return err
+	}
+	d := p.lookup(string(runes(name)))
+	if d == nil || d.machine != nil {
+		return fmt.Errorf("%q: %w", string(runes(name)), ErrUndefinedMacro)
+	} else if len(body) > len(d.body) {
+		return fmt.Errorf("%q: %w", string(runes(name)), ErrUpdateTooLong)
+	}
+	d.body = append([]int{}, body...)
+	return nil
+}
+
+// bin converts a signed decimal number to a binary word.
+func bin(p *Processor, items [][]int, out sink) error {
+	text, err := arg(items, 1)
+	if err != nil {
+		return err
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(string(runes(text)), "+"))
+	if err != nil {
+		return fmt.Errorf("%q: %w", string(runes(text)), ErrBadNumber)
+	}
+	out.put(n)
+	return nil
+}
+
+// dec converts a binary word to a signed decimal number.
+func dec(p *Processor, items [][]int, out sink) error {
+	n, err := word(items, 1)
+	if err != nil {
+		return err
+	}
+	for _, ch := range strconv.Itoa(n) {
+		out.put(int(ch))
+	}
+	return nil
+}
+
+// bar applies a binary arithmetic operator to two binary words.
+func bar(p *Processor, items [][]int, out sink) error {
+	operator, err := arg(items, 1)
+	if err != nil {
+		return err
+	} else if len(operator) != 1 {
+		return fmt.Errorf("%q: %w", string(runes(operator)), ErrBadOperator)
+	}
+	a, err := word(items, 2)
+	if err != nil {
+		return err
+	}
+	b, err := word(items, 3)
+	if err != nil {
+		return err
+	}
+	switch operator[0] {
+	case '+':
+		out.put(a + b)
+	case '-':
+		out.put(a - b)
+	case '*':
+		out.put(a * b)
+	case '/', 'R':
+		if b == 0 {
+			return fmt.Errorf("division by zero: %w", ErrBadNumber)
+		} else if operator[0] == '/' {
+			out.put(a / b)
+		} else {
+			out.put(a % b)
+		}
+	default:
+		return fmt.Errorf("%q: %w", string(runes(operator)), ErrBadOperator)
+	}
+	return nil
+}
@@ -11,22 +11,47 @@ import (
 	"github.com/maloquacious/ml_i/pkg/lowl/ast"
 	"github.com/maloquacious/ml_i/pkg/lowl/op"
 	"github.com/maloquacious/ml_i/pkg/lowl/vm"
+	"io"
 	"os"
 	"sort"
 )
 
+// Options controls the side effects of the assembler.
+type Options struct {
+	Listing     string    // when set, write the assembly listing to this file
+	SymbolTable string    // when set, write the symbol table to this file
+	Log         io.Writer // when set, write warnings and progress messages here
+	StackSize   int       // words reserved for the stacks after the program; zero means vm.MAX_STACK
+	WordSize    int       // bits in a machine word, 16 or 32; zero means unbounded
+}
+
+// Assemble assembles the nodes, writing the listing and symbol table
+// to the current directory and progress messages to stdout.
 func Assemble(nodes ast.Nodes) (*vm.VM, error) {
+	return AssembleWithOptions(nodes, Options{
+		Listing:     "asm_listing.txt",
+		SymbolTable: "asm_symtab.txt",
+		Log:         os.Stdout,
+	})
+}
+
+// AssembleWithOptions assembles the nodes and returns a VM that can run them.
+func AssembleWithOptions(nodes ast.Nodes, opts Options) (*vm.VM, error) {
 	// create symbol table and initialize it with required constants
 	symtab := newSymbolTable()
-	symtab.InsertConstant(-1, "LCH", 1)       // LCH is the length (in words) of a character
-	symtab.InsertConstant(-1, "LNM", 1)       // LMN is the length (in words) of a number
-	symtab.InsertConstant(-1, "LICH", 1)      // LICH is the inverse of LCH
-	symtab.InsertConstant(-1, "NLREP", '\n')  // new-line
-	symtab.InsertConstant(-1, "QUTREP", '"')  // quote mark
-	symtab.InsertConstant(-1, "SPREP", ' ')   // space
-	symtab.InsertConstant(-1, "TABREP", '\t') // tab
+	symtab.InsertConstant(-1, "LCH", 1)         // LCH is the length (in words) of a character
+	symtab.InsertConstant(-1, "LNM", 1)         // LMN is the length (in words) of a number
+	symtab.InsertConstant(-1, "LICH", 1)        // LICH is the inverse of LCH
+	symtab.InsertConstant(-1, "NLREP", '\n')    // new-line
+	symtab.InsertConstant(-1, "QUTREP", '"')    // quote mark
+	symtab.InsertConstant(-1, "SPREP", ' ')     // space
+	symtab.InsertConstant(-1, "TABREP", '\t')   // tab
+	symtab.InsertConstant(-1, "EOFREP", vm.EOF) // end of input (MDREAD)
 
 	machine := vm.New()
+	if err := machine.SetWordSize(opts.WordSize); err != nil {
+		return nil, err
+	}
 
 	// the current subroutine name is set whenever we get a SUBR instruction.
 	// it is used as a sanity check in the EXIT calls
@@ -49,8 +74,6 @@ func Assemble(nodes ast.Nodes) (*vm.VM, error) {
 		switch node.Op {
 
 		// this section implements instructions that look like "OP"
-		case op.ALIGN:
-			// ALIGN emits no code
 		case op.BMOVE, op.FMOVE:
 			if sym, ok := symtab.Lookup("SRCPT"); !ok {
 				return nil, fmt.Errorf("%d: %d: internal error: SRCPT undefined", node.Line, node.Col)
@@ -71,11 +94,11 @@ func Assemble(nodes ast.Nodes) (*vm.VM, error) {
 				return nil, fmt.Errorf("%d: %d: internal error: LFPT undefined", node.Line, node.Col)
 			}
 			machine.Core[machine.PC], machine.PC = word, machine.PC+1
-		case op.CSS:
+		case op.ALIGN, op.CSS:
 			machine.Core[machine.PC], machine.PC = word, machine.PC+1
 		case op.PRGEN:
 			machine.Core[machine.PC], machine.PC = vm.Word{Op: op.HALT}, machine.PC+1
-		case op.GOTBL, op.MDERCH, op.MDQUIT, op.NOOP, op.UNKNOWN:
+		case op.GOTBL, op.MDCALL, op.MDERCH, op.MDQUIT, op.NOOP, op.UNKNOWN:
 			// some op codes are not available to callers
 			return nil, fmt.Errorf("%d: %d: %s: internal error", node.Line, node.Col, node.Op)
 
@@ -233,12 +256,11 @@ func Assemble(nodes ast.Nodes) (*vm.VM, error) {
 			}
 			switch label := node.Parameters[0]; label.Kind {
 			case ast.Variable:
-				switch label.Text {
-				case "MDERCH": // special action needed MD functions
-					word.Op = op.MDERCH
-				case "MDQUIT": // special action needed MD functions
-					word.Op = op.MDQUIT
-				default:
+				if _, ok := vm.LookupMD(label.Text); ok {
+					// machine-dependent subroutines are implemented by the host
+					word.Op = op.MDCALL
+					word.Text = label.Text
+				} else {
 					symtab.AddReference(label.Text, machine.PC)
 				}
 			default:
@@ -353,7 +375,8 @@ func Assemble(nodes ast.Nodes) (*vm.VM, error) {
 			}
 			switch text := node.Parameters[0]; text.Kind {
 			case ast.QuotedText:
-				// no action needed
+				// the program name is used by the debugging tools
+				machine.Name = text.Text
 			default:
 				return nil, fmt.Errorf("%d: %s: %s: not allowed", node.Line, node.Op, text.Kind)
 			}
@@ -414,7 +437,7 @@ func Assemble(nodes ast.Nodes) (*vm.VM, error) {
 			case ast.Variable:
 				switch flag.Text {
 				case "A": // compare unsigned addresses
-					// no special action needed
+					word.ValueTwo = vm.CompareAddresses
 				case "X": // compare signed numbers
 					// no special action needed
 				default:
@@ -620,26 +643,45 @@ func Assemble(nodes ast.Nodes) (*vm.VM, error) {
 
 	machine.Registers.Last = machine.PC
 
+	// reserve the stack region after the program. Run points FFPT and
+	// LFPT at its bounds, so the stacks never grow over the program.
+	stackSize := opts.StackSize
+	if stackSize == 0 {
+		stackSize = vm.MAX_STACK
+	}
+	if stackSize < 0 || machine.PC+stackSize > len(machine.Core) || !vm.FitsWord(opts.WordSize, machine.PC+stackSize) {
+		return nil, fmt.Errorf("stack size %d: program ends at %d: memory is %d words", stackSize, machine.PC, len(machine.Core))
+	}
+	machine.Registers.StackStart, machine.Registers.StackEnd = machine.PC, machine.PC+stackSize
+	printf(opts.Log, "asm: set vm stack   %-12s %6d %6d\n", "", machine.Registers.StackStart, machine.Registers.StackEnd)
+
 	// when we start running the machine, the PC should be set to the first
 	// instruction in the program. if there is no BEGIN label, the PC will
 	// point to a HALT instruction.
 	if sym, ok := symtab.Lookup("BEGIN"); !ok {
-		fmt.Printf("asm: warning: BEGIN not set\n")
+		printf(opts.Log, "asm: warning: BEGIN not set\n")
 	} else {
 		if sym.kind != "address" {
 			panic("BEGIN must be a label")
 		}
-		fmt.Printf("asm: set vm begin   %-12s %6d\n", "", sym.address)
+		printf(opts.Log, "asm: set vm begin   %-12s %6d\n", "", sym.address)
 		machine.Registers.Start = sym.address
 	}
 
+	// stack overflow branches to ERLSO if the program defines it.
+	// otherwise, the machine stops with a stack overflow error.
+	if sym, ok := symtab.Lookup("ERLSO"); ok && sym.kind == "address" {
+		printf(opts.Log, "asm: set vm erlso   %-12s %6d\n", "", sym.address)
+		machine.Registers.ERLSO = sym.address
+	}
+
 	// detect and report undefined symbols
 	undefinedSymbols := 0
 	for _, sym := range symtab.symbols {
 		if sym.kind != "undefined" && sym.line != 0 {
 			continue
 		}
-		fmt.Printf("asm: error: undefined symbol %q %q\n", sym.name, sym.kind)
+		printf(opts.Log, "asm: error: undefined symbol %q %q\n", sym.name, sym.kind)
 		undefinedSymbols++
 	}
 	if undefinedSymbols != 0 {
@@ -679,6 +721,27 @@ func Assemble(nodes ast.Nodes) (*vm.VM, error) {
 		}
 	}
 
+	// reject literals, constants and OF(...) results that don't fit in a word
+	for pc := 0; pc < machine.Registers.Last; pc++ {
+		word := machine.Core[pc]
+		if word.Source.Line == 0 {
+			continue
+		}
+		for _, value := range []int{word.Value, word.ValueTwo} {
+			if !vm.FitsWord(opts.WordSize, value) {
+				return nil, fmt.Errorf("%d: %s: %d does not fit in a %d-bit word", word.Source.Line, word.Source.Op, value, opts.WordSize)
+			}
+		}
+	}
+
+	// export the addresses of labels and variables for the debugging tools
+	machine.Symbols = make(map[string]int)
+	for _, sym := range symtab.symbols {
+		if sym.kind == "address" {
+			machine.Symbols[sym.name] = sym.address
+		}
+	}
+
 	// dump the symbol table
 	fpListing := &bytes.Buffer{}
 	var list []string
@@ -709,10 +772,20 @@ func Assemble(nodes ast.Nodes) (*vm.VM, error) {
 		}
 	}
 
-	_ = os.WriteFile("asm_symtab.txt", fpListing.Bytes(), 0644)
-	if err := Listing("asm_listing.txt", machine, symtab); err != nil {
-		return nil, err
+	if opts.SymbolTable != "" {
+		_ = os.WriteFile(opts.SymbolTable, fpListing.Bytes(), 0644)
+	}
+	if opts.Listing != "" {
+		if err := Listing(opts.Listing, machine, symtab); err != nil {
+			return nil, err
+		}
 	}
 
 	return machine, nil
 }
+
+func printf(w io.Writer, format string, args ...any) {
+	if w != nil {
+		_, _ = fmt.Fprintf(w, format, args...)
+	}
+}
@@ -0,0 +1,125 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package assembler_test
+
+import (
+	"github.com/maloquacious/ml_i/pkg/lowl/assembler"
+	"github.com/maloquacious/ml_i/pkg/lowl/ast"
+	"github.com/maloquacious/ml_i/pkg/lowl/cst"
+	"github.com/maloquacious/ml_i/pkg/lowl/vm"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// parse returns the syntax tree for the source.
+func parse(t *testing.T, source string) ast.Nodes {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "test.lowl")
+	if err := os.WriteFile(name, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	parseTree, err := cst.Parse(name, false, false)
+	if err != nil {
+		t.Fatalf("cst: want nil: got %v\n", err)
+	}
+	nodes, err := ast.Parse(parseTree)
+	if err != nil {
+		t.Fatalf("ast: want nil: got %v\n", err)
+	}
+	return nodes
+}
+
+// program returns a minimal program with the given instruction at BEGIN.
+func program(instruction string) string {
+	return strings.Join([]string{
+		"        PRGST 'TEST'",
+		"        DCL   FFPT",
+		"        DCL   LFPT",
+		"        DCL   PARNM",
+		"[BEGIN] " + instruction,
+		"        GOSUB MDQUIT,X",
+		"        PRGEN",
+		"",
+	}, "\n")
+}
+
+// TestStackSize tests that the stack region is reserved after the program.
+func TestStackSize(t *testing.T) {
+	nodes := parse(t, program("LAL   7"))
+
+	for _, tc := range []struct {
+		stackSize, want int
+	}{
+		{0, vm.MAX_STACK},
+		{100, 100},
+	} {
+		m, err := assembler.AssembleWithOptions(nodes, assembler.Options{StackSize: tc.stackSize})
+		if err != nil {
+			t.Fatalf("%d: want nil: got %v\n", tc.stackSize, err)
+		}
+		last := m.Registers.Last
+		if m.Registers.StackStart != last || m.Registers.StackEnd != last+tc.want {
+			t.Errorf("%d: stack: want %d:%d: got %d:%d\n", tc.stackSize, last, last+tc.want, m.Registers.StackStart, m.Registers.StackEnd)
+		}
+		// the run points FFPT and LFPT at the bounds of the region
+		m.Reset(nil, nil)
+		ffpt, lfpt := m.Core[m.Registers.FFPT].Value, m.Core[m.Registers.LFPT].Value
+		if ffpt != last || lfpt != last+tc.want {
+			t.Errorf("%d: ffpt, lfpt: want %d, %d: got %d, %d\n", tc.stackSize, last, last+tc.want, ffpt, lfpt)
+		}
+	}
+
+	// a region that runs past the end of memory is rejected
+	m, err := assembler.AssembleWithOptions(nodes, assembler.Options{StackSize: 1})
+	if err != nil {
+		t.Fatalf("fits: want nil: got %v\n", err)
+	}
+	room := len(m.Core) - m.Registers.Last
+	if _, err := assembler.AssembleWithOptions(nodes, assembler.Options{StackSize: room}); err != nil {
+		t.Errorf("%d: want nil: got %v\n", room, err)
+	}
+	for _, stackSize := range []int{room + 1, -1} {
+		if _, err := assembler.AssembleWithOptions(nodes, assembler.Options{StackSize: stackSize}); err == nil || !strings.Contains(err.Error(), "stack size") {
+			t.Errorf("%d: want stack size error: got %v\n", stackSize, err)
+		}
+	}
+
+	// with 16-bit words, the end of the region must fit in a word
+	if _, err := assembler.AssembleWithOptions(nodes, assembler.Options{StackSize: room, WordSize: 16}); err == nil || !strings.Contains(err.Error(), "stack size") {
+		t.Errorf("16-bit %d: want stack size error: got %v\n", room, err)
+	}
+	if _, err := assembler.AssembleWithOptions(nodes, assembler.Options{StackSize: room - 1, WordSize: 16}); err != nil {
+		t.Errorf("16-bit %d: want nil: got %v\n", room-1, err)
+	}
+}
+
+// TestWordSize tests that values that don't fit in a word are rejected.
+func TestWordSize(t *testing.T) {
+	for _, tc := range []struct {
+		instruction string
+		wordSize    int
+		err         string
+	}{
+		{"LAL   32767", 16, ""},
+		{"LAL   65535", 16, ""},
+		{"LAL   -32768", 16, ""},
+		{"LAL   70000", 0, ""},
+		{"LAL   70000", 32, ""},
+		{"LAL   70000", 16, "5: LAL: 70000 does not fit in a 16-bit word"},
+		{"LAL   -40000", 16, "5: LAL: -40000 does not fit in a 16-bit word"},
+		{"AAL   OF(LNM+40000)", 16, ""},
+		{"AAL   OF(LNM+70000)", 16, "5: AAL: 70001 does not fit in a 16-bit word"},
+		{"AAL   OF(LNM+70000)", 32, ""},
+	} {
+		_, err := assembler.AssembleWithOptions(parse(t, program(tc.instruction)), assembler.Options{WordSize: tc.wordSize})
+		if tc.err == "" && err != nil {
+			t.Errorf("%q %d: want nil: got %v\n", tc.instruction, tc.wordSize, err)
+		} else if tc.err != "" && (err == nil || err.Error() != tc.err) {
+			t.Errorf("%q %d: want %q: got %v\n", tc.instruction, tc.wordSize, tc.err, err)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package dap
+
+import "fmt"
+
+var (
+	ErrBadHeader    = fmt.Errorf("bad header")
+	ErrNoProgram    = fmt.Errorf("no program to debug")
+	ErrNotLaunched  = fmt.Errorf("program not launched")
+	ErrNotSupported = fmt.Errorf("not supported")
+	ErrRunning      = fmt.Errorf("program is running")
+)
@@ -0,0 +1,482 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+// Package dap implements a Debug Adapter Protocol server for programs
+// running on the LOWL virtual machine.
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/maloquacious/ml_i/pkg/lowl/debugger"
+	"github.com/maloquacious/ml_i/pkg/lowl/op"
+	"github.com/maloquacious/ml_i/pkg/lowl/vm"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// LoadFunc assembles a program and returns a machine that can run it.
+// It may return a nil machine and no error when there is nothing to run,
+// for example when the program is only being scanned.
+type LoadFunc func(program string) (*vm.VM, error)
+
+// LaunchArguments are the arguments of the launch request.
+type LaunchArguments struct {
+	Program     string `json:"program"`               // LOWL source file
+	Input       string `json:"input,omitempty"`       // file read by the program
+	StopOnEntry bool   `json:"stopOnEntry,omitempty"` // stop before the first instruction
+}
+
+// Server serves the Debug Adapter Protocol for a single debug session.
+type Server struct {
+	r    *bufio.Reader
+	w    io.Writer
+	load LoadFunc
+
+	mu      sync.Mutex // protects the fields below and writes to w
+	seq     int
+	d       *debugger.Debugger
+	program string
+	launch  LaunchArguments
+	running bool
+	closers []io.Closer
+
+	wg sync.WaitGroup // tracks the goroutine running the program
+}
+
+// threadID is the id of the only thread.
+const threadID = 1
+
+// variable references for the scopes.
+const (
+	registersRef = 1
+	variablesRef = 2
+)
+
+// NewServer returns a server that reads requests from r and writes
+// responses and events to w.
+func NewServer(r io.Reader, w io.Writer, load LoadFunc) *Server {
+	return &Server{r: bufio.NewReader(r), w: w, load: load}
+}
+
+// Serve handles requests until the client disconnects or the input ends.
+func (s *Server) Serve() error {
+	defer s.close()
+	for {
+		msg, err := readMessage(s.r)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if msg.Type != "request" {
+			continue
+		}
+		if done := s.dispatch(msg); done {
+			return nil
+		}
+	}
+}
+
+// dispatch handles one request. It returns true when the session is over.
+func (s *Server) dispatch(req *message) bool {
+	switch req.Command {
+	case "initialize":
+		s.respond(req, map[string]any{
+			"supportsConfigurationDoneRequest": true,
+			"supportsEvaluateForHovers":        true,
+			"supportsStepBack":                 true,
+			"supportsTerminateRequest":         true,
+		})
+	case "launch":
+		var args LaunchArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			s.fail(req, err)
+		} else if err = s.start(args); err != nil {
+			s.fail(req, err)
+		} else {
+			s.respond(req, nil)
+			s.event("initialized", nil)
+		}
+	case "setBreakpoints":
+		s.setBreakpoints(req)
+	case "setExceptionBreakpoints":
+		s.respond(req, map[string]any{"breakpoints": []any{}})
+	case "configurationDone":
+		s.respond(req, nil)
+		s.mu.Lock()
+		stopOnEntry := s.launch.StopOnEntry
+		s.mu.Unlock()
+		if stopOnEntry {
+			s.stopped("entry", "")
+		} else {
+			s.resume(req, (*debugger.Debugger).Continue)
+		}
+	case "threads":
+		s.respond(req, map[string]any{"threads": []any{map[string]any{"id": threadID, "name": s.name()}}})
+	case "stackTrace":
+		s.stackTrace(req)
+	case "scopes":
+		s.respond(req, map[string]any{"scopes": []any{
+			map[string]any{"name": "Registers", "variablesReference": registersRef, "expensive": false},
+			map[string]any{"name": "Variables", "variablesReference": variablesRef, "expensive": false},
+		}})
+	case "variables":
+		s.variables(req)
+	case "evaluate":
+		s.evaluate(req)
+	case "continue":
+		s.resume(req, (*debugger.Debugger).Continue)
+	case "next":
+		s.resume(req, (*debugger.Debugger).StepOver)
+	case "stepIn":
+		s.resume(req, (*debugger.Debugger).Step)
+	case "stepOut":
+		s.resume(req, (*debugger.Debugger).StepOut)
+	case "stepBack":
+		s.resume(req, (*debugger.Debugger).StepBack)
+	case "reverseContinue":
+		s.resume(req, (*debugger.Debugger).ReverseContinue)
+	case "pause":
+		// running is set before the goroutine starts the debugger, and the
+		// debugger keeps an interrupt that arrives before the run begins.
+		s.mu.Lock()
+		if s.d != nil && s.running {
+			s.d.Interrupt()
+		}
+		s.mu.Unlock()
+		s.respond(req, nil)
+	case "disconnect", "terminate":
+		s.respond(req, nil)
+		return true
+	default:
+		s.fail(req, fmt.Errorf("%s: %w", req.Command, ErrNotSupported))
+	}
+	return false
+}
+
+// start loads the program and prepares the debugger.
+func (s *Server) start(args LaunchArguments) error {
+	if s.load == nil {
+		return fmt.Errorf("launch: %w", ErrNotSupported)
+	}
+	m, err := s.load(args.Program)
+	if err != nil {
+		return err
+	} else if m == nil {
+		return fmt.Errorf("%s: %w", args.Program, ErrNoProgram)
+	}
+	if args.Input != "" {
+		fp, err := os.Open(args.Input)
+		if err != nil {
+			return err
+		}
+		s.closers = append(s.closers, fp)
+		m.AddInput(args.Input, fp)
+	}
+	m.Reset(&outputWriter{s: s, category: "stdout"}, &outputWriter{s: s, category: "console"})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.d, s.program, s.launch = debugger.New(m), args.Program, args
+	return nil
+}
+
+// resume runs the debugger in the background and reports where it stops.
+func (s *Server) resume(req *message, run func(*debugger.Debugger) debugger.Stop) {
+	s.mu.Lock()
+	d, running := s.d, s.running
+	if d != nil && !running {
+		s.running = true
+	}
+	s.mu.Unlock()
+	if d == nil {
+		s.fail(req, ErrNotLaunched)
+		return
+	} else if running {
+		s.fail(req, ErrRunning)
+		return
+	}
+	if req.Command == "continue" {
+		s.respond(req, map[string]any{"allThreadsContinued": true})
+	} else if req.Command != "configurationDone" {
+		s.respond(req, nil)
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		stop := run(d)
+		s.mu.Lock()
+		s.running = false
+		s.mu.Unlock()
+		switch stop.Reason {
+		case debugger.Stepped:
+			s.stopped("step", "")
+		case debugger.Breakpoint:
+			s.stopped("breakpoint", "")
+		case debugger.Watchpoint:
+			var changes []string
+			for _, change := range stop.Changes {
+				changes = append(changes, change.String())
+			}
+			s.stopped("data breakpoint", strings.Join(changes, ", "))
+		case debugger.Paused:
+			s.stopped("pause", "")
+		case debugger.NoHistory:
+			s.stopped("step", stop.Reason.String())
+		case debugger.Failed:
+			s.stopped("exception", stop.Err.Error())
+		case debugger.Halted, debugger.Quit:
+			s.event("exited", map[string]any{"exitCode": 0})
+			s.event("terminated", nil)
+		}
+	}()
+}
+
+// setBreakpoints replaces all the breakpoints with the requested source lines.
+// The debugger allows breakpoints to be changed while the program is running.
+func (s *Server) setBreakpoints(req *message) {
+	var args struct {
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+		Lines []int `json:"lines"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		s.fail(req, err)
+		return
+	}
+	lines := args.Lines
+	if len(args.Breakpoints) != 0 {
+		lines = nil
+		for _, bp := range args.Breakpoints {
+			lines = append(lines, bp.Line)
+		}
+	}
+
+	s.mu.Lock()
+	d := s.d
+	s.mu.Unlock()
+	var result []any
+	if d != nil {
+		d.ClearAll()
+	}
+	for n, line := range lines {
+		bp := map[string]any{"id": n + 1, "line": line, "verified": false}
+		if d != nil {
+			if _, err := d.Break(fmt.Sprintf("line:%d", line)); err == nil {
+				bp["verified"] = true
+			} else {
+				bp["message"] = err.Error()
+			}
+		}
+		result = append(result, bp)
+	}
+	if result == nil {
+		result = []any{}
+	}
+	s.respond(req, map[string]any{"breakpoints": result})
+}
+
+// stackTrace reports the return stack as the call stack.
+func (s *Server) stackTrace(req *message) {
+	d := s.stoppedDebugger(req)
+	if d == nil {
+		return
+	}
+	source := map[string]any{"name": filepath.Base(s.program), "path": s.program}
+	var frames []any
+	for n, frame := range d.Frames() {
+		line := 0
+		if 0 <= frame.PC && frame.PC < len(d.VM().Core) {
+			line = d.VM().Core[frame.PC].Source.Line
+		}
+		frames = append(frames, map[string]any{
+			"id":                          n,
+			"name":                        frame.Name,
+			"source":                      source,
+			"line":                        line,
+			"column":                      1,
+			"instructionPointerReference": fmt.Sprintf("%d", frame.PC),
+		})
+	}
+	s.respond(req, map[string]any{"stackFrames": frames, "totalFrames": len(frames)})
+}
+
+// variables reports the registers or the named variables.
+func (s *Server) variables(req *message) {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		s.fail(req, err)
+		return
+	}
+	d := s.stoppedDebugger(req)
+	if d == nil {
+		return
+	}
+	m := d.VM()
+	variable := func(name string, value any) map[string]any {
+		return map[string]any{"name": name, "value": fmt.Sprintf("%v", value), "variablesReference": 0}
+	}
+	var vars []any
+	switch args.VariablesReference {
+	case registersRef:
+		vars = append(vars,
+			variable("A", m.A),
+			variable("B", m.B),
+			variable("C", m.C),
+			variable("CMP", m.Registers.Cmp),
+			variable("PC", m.PC),
+			variable("EXIT", m.Registers.JumpValue),
+		)
+	case variablesRef:
+		var names []string
+		for name, address := range m.Symbols {
+			if 0 <= address && address < len(m.Core) && m.Core[address].Source.Op == op.DCL {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			value, _ := m.Load(m.Symbols[name]) // address checked above
+			vars = append(vars, variable(name, value))
+		}
+	}
+	if vars == nil {
+		vars = []any{}
+	}
+	s.respond(req, map[string]any{"variables": vars})
+}
+
+// evaluate returns the value of a register, a variable or an address.
+func (s *Server) evaluate(req *message) {
+	var args struct {
+		Expression string `json:"expression"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		s.fail(req, err)
+		return
+	}
+	d := s.stoppedDebugger(req)
+	if d == nil {
+		return
+	}
+	m := d.VM()
+	var value int
+	switch args.Expression {
+	case "A":
+		value = m.A
+	case "B":
+		value = m.B
+	case "C":
+		value = m.C
+	case "PC":
+		value = m.PC
+	default:
+		address, err := d.Resolve(args.Expression)
+		if err != nil {
+			s.fail(req, err)
+			return
+		} else if value, err = m.Load(address); err != nil {
+			s.fail(req, err)
+			return
+		}
+	}
+	s.respond(req, map[string]any{"result": fmt.Sprintf("%d", value), "variablesReference": 0})
+}
+
+// stoppedDebugger returns the debugger if the program is launched and
+// stopped. Otherwise, it fails the request and returns nil.
+func (s *Server) stoppedDebugger(req *message) *debugger.Debugger {
+	s.mu.Lock()
+	d, running := s.d, s.running
+	s.mu.Unlock()
+	if d == nil {
+		s.fail(req, ErrNotLaunched)
+		return nil
+	} else if running {
+		s.fail(req, ErrRunning)
+		return nil
+	}
+	return d
+}
+
+// name returns the name of the program.
+func (s *Server) name() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.d != nil && s.d.VM().Name != "" {
+		return s.d.VM().Name
+	}
+	return "main"
+}
+
+func (s *Server) stopped(reason, text string) {
+	body := map[string]any{"reason": reason, "threadId": threadID, "allThreadsStopped": true}
+	if text != "" {
+		body["text"] = text
+		body["description"] = text
+	}
+	s.event("stopped", body)
+}
+
+func (s *Server) respond(req *message, body any) {
+	s.send(&response{Type: "response", RequestSeq: req.Seq, Success: true, Command: req.Command, Body: body})
+}
+
+func (s *Server) fail(req *message, err error) {
+	s.send(&response{Type: "response", RequestSeq: req.Seq, Success: false, Command: req.Command, Message: err.Error()})
+}
+
+func (s *Server) event(name string, body any) {
+	s.send(&event{Type: "event", Event: name, Body: body})
+}
+
+// send numbers and writes a message.
+func (s *Server) send(msg any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	switch msg := msg.(type) {
+	case *response:
+		msg.Seq = s.seq
+	case *event:
+		msg.Seq = s.seq
+	}
+	_ = writeMessage(s.w, msg)
+}
+
+// close stops the program and releases the files opened for it.
+func (s *Server) close() {
+	s.mu.Lock()
+	if s.d != nil {
+		s.d.Interrupt()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	for _, c := range s.closers {
+		_ = c.Close()
+	}
+}
+
+// outputWriter sends the program's output to the client as output events.
+type outputWriter struct {
+	s        *Server
+	category string
+}
+
+func (ow *outputWriter) Write(p []byte) (int, error) {
+	ow.s.event("output", map[string]any{"category": ow.category, "output": string(p)})
+	return len(p), nil
+}
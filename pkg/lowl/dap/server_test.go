@@ -0,0 +1,197 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package dap_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/maloquacious/ml_i/pkg/lowl/dap"
+	"github.com/maloquacious/ml_i/pkg/lowl/op"
+	"github.com/maloquacious/ml_i/pkg/lowl/vm"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// load returns a program that calls a subroutine to print "hi".
+func load(program string) (*vm.VM, error) {
+	m := &vm.VM{Name: "HI"}
+	for pc, w := range []vm.Word{
+		{Op: op.HALT},                   // 0
+		{Op: op.GOSUB, Value: 4},        // 1 BEGIN
+		{Op: op.MESS, Text: "bye$"},     // 2
+		{Op: op.MDCALL, Text: "MDQUIT"}, // 3
+		{Op: op.NOOP, Text: "SAYHI"},    // 4 SAYHI
+		{Op: op.MESS, Text: "hi$"},      // 5
+		{Op: op.EXIT, Value: 1},         // 6
+	} {
+		w.Source.Line = pc + 1
+		m.SetWord(pc, w)
+	}
+	m.Core[4].Source.Op = op.SUBR
+	m.Core[7].Value = 70000 // COUNT, stored without wrapping
+	m.Registers.Start, m.Registers.Last = 1, 7
+	m.Symbols = map[string]int{"BEGIN": 1, "SAYHI": 4, "COUNT": 7}
+	_ = m.SetWordSize(16)
+	return m, nil
+}
+
+type client struct {
+	t   *testing.T
+	w   io.Writer
+	r   *bufio.Reader
+	seq int
+}
+
+func (c *client) send(command string, args any) {
+	c.seq++
+	data, _ := json.Marshal(map[string]any{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	_, _ = fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+// read returns the next message from the server.
+func (c *client) read() map[string]any {
+	header, err := textproto.NewReader(c.r).ReadMIMEHeader()
+	if err != nil {
+		c.t.Fatalf("read: %v\n", err)
+	}
+	length, _ := strconv.Atoi(header.Get("Content-Length"))
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		c.t.Fatalf("read: %v\n", err)
+	}
+	var msg map[string]any
+	if err := json.Unmarshal(data, &msg); err != nil {
+		c.t.Fatalf("read: %v\n", err)
+	}
+	return msg
+}
+
+// expect reads messages until it finds the response or event, collecting output events.
+func (c *client) expect(kind, name string, output *string) map[string]any {
+	for {
+		msg := c.read()
+		switch {
+		case msg["type"] == "event" && msg["event"] == "output" && !(kind == "event" && name == "output"):
+			*output += msg["body"].(map[string]any)["output"].(string)
+		case msg["type"] == kind && (msg["command"] == name || msg["event"] == name):
+			if kind == "response" && msg["success"] != true {
+				c.t.Fatalf("%s: want success: got %v\n", name, msg["message"])
+			}
+			return msg
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	toServer, fromClient := io.Pipe()
+	fromServer, toClient := io.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- dap.NewServer(toServer, toClient, load).Serve()
+		_ = toClient.Close()
+	}()
+	c, output := &client{t: t, w: fromClient, r: bufio.NewReader(fromServer)}, ""
+
+	c.send("initialize", map[string]any{"adapterID": "lowl"})
+	c.expect("response", "initialize", &output)
+	c.send("launch", map[string]any{"program": "hi.lowl"})
+	c.expect("response", "launch", &output)
+	c.expect("event", "initialized", &output)
+	c.send("setBreakpoints", map[string]any{"source": map[string]any{"path": "hi.lowl"}, "breakpoints": []any{map[string]any{"line": 6}, map[string]any{"line": 99}}})
+	bps := c.expect("response", "setBreakpoints", &output)["body"].(map[string]any)["breakpoints"].([]any)
+	if len(bps) != 2 || bps[0].(map[string]any)["verified"] != true || bps[1].(map[string]any)["verified"] != false {
+		t.Errorf("breakpoints: want verified, unverified: got %v\n", bps)
+	}
+	c.send("configurationDone", nil)
+	c.expect("response", "configurationDone", &output)
+	if reason := c.expect("event", "stopped", &output)["body"].(map[string]any)["reason"]; reason != "breakpoint" {
+		t.Errorf("stopped: want breakpoint: got %v\n", reason)
+	}
+
+	c.send("stackTrace", map[string]any{"threadId": 1})
+	frames := c.expect("response", "stackTrace", &output)["body"].(map[string]any)["stackFrames"].([]any)
+	if len(frames) != 2 {
+		t.Fatalf("stackTrace: want 2 frames: got %d\n", len(frames))
+	}
+	for n, want := range []struct {
+		name string
+		line float64
+	}{{"SAYHI", 6}, {"HI", 2}} {
+		frame := frames[n].(map[string]any)
+		if frame["name"] != want.name || frame["line"] != want.line {
+			t.Errorf("frame %d: want %s %v: got %v %v\n", n, want.name, want.line, frame["name"], frame["line"])
+		}
+	}
+
+	c.send("variables", map[string]any{"variablesReference": 1})
+	vars := c.expect("response", "variables", &output)["body"].(map[string]any)["variables"].([]any)
+	if len(vars) == 0 || vars[0].(map[string]any)["name"] != "A" {
+		t.Errorf("variables: want A first: got %v\n", vars)
+	}
+
+	c.send("next", map[string]any{"threadId": 1})
+	c.expect("response", "next", &output)
+	if reason := c.expect("event", "stopped", &output)["body"].(map[string]any)["reason"]; reason != "step" {
+		t.Errorf("next: want step: got %v\n", reason)
+	}
+
+	c.send("stepBack", map[string]any{"threadId": 1})
+	c.expect("response", "stepBack", &output)
+	if reason := c.expect("event", "stopped", &output)["body"].(map[string]any)["reason"]; reason != "step" {
+		t.Errorf("stepBack: want step: got %v\n", reason)
+	}
+	c.send("evaluate", map[string]any{"expression": "PC"})
+	if result := c.expect("response", "evaluate", &output)["body"].(map[string]any)["result"]; result != "5" {
+		t.Errorf("stepBack: want pc 5: got %v\n", result)
+	}
+	c.send("evaluate", map[string]any{"expression": "COUNT"})
+	if result := c.expect("response", "evaluate", &output)["body"].(map[string]any)["result"]; result != "4464" {
+		t.Errorf("evaluate: want count wrapped to 4464: got %v\n", result)
+	}
+
+	c.send("continue", map[string]any{"threadId": 1})
+	c.expect("response", "continue", &output)
+	c.expect("event", "terminated", &output)
+	if output != "hi\nhi\nbye\n" {
+		t.Errorf("output: want %q: got %q\n", "hi\nhi\nbye\n", output)
+	}
+
+	c.send("disconnect", nil)
+	c.expect("response", "disconnect", &output)
+	if err := <-done; err != nil {
+		t.Errorf("serve: want nil: got %v\n", err)
+	}
+}
+
+// TestLaunchNoMachine tests that launch fails when the loader returns no machine.
+func TestLaunchNoMachine(t *testing.T) {
+	toServer, fromClient := io.Pipe()
+	fromServer, toClient := io.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- dap.NewServer(toServer, toClient, func(program string) (*vm.VM, error) {
+			return nil, nil
+		}).Serve()
+		_ = toClient.Close()
+	}()
+	c, output := &client{t: t, w: fromClient, r: bufio.NewReader(fromServer)}, ""
+
+	c.send("launch", map[string]any{"program": "hi.lowl"})
+	if msg := c.read(); msg["command"] != "launch" || msg["success"] != false {
+		t.Errorf("launch: want failure: got %v\n", msg)
+	} else if message, _ := msg["message"].(string); !strings.Contains(message, dap.ErrNoProgram.Error()) {
+		t.Errorf("launch: want %q: got %q\n", dap.ErrNoProgram, message)
+	}
+
+	c.send("disconnect", nil)
+	c.expect("response", "disconnect", &output)
+	if err := <-done; err != nil {
+		t.Errorf("serve: want nil: got %v\n", err)
+	}
+}
@@ -0,0 +1,467 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+// Package debugger implements breakpoints, watchpoints and stepping
+// for programs running on the LOWL virtual machine.
+package debugger
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/maloquacious/ml_i/pkg/lowl/op"
+	"github.com/maloquacious/ml_i/pkg/lowl/vm"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Debugger controls a machine one step at a time.
+type Debugger struct {
+	m           *vm.VM
+	mu          sync.Mutex // protects breakpoints, which may be set while running
+	breakpoints map[int]bool
+	watches     map[string]*watch
+	labels      map[int][]string // symbol names by address
+	addresses   []int            // sorted addresses of the symbols
+	lines       map[int]int      // address of the first instruction for each source line
+
+	// bounds of the forwards and backwards stacks when the program started
+	ffBase, lfBase int
+
+	// snapshot of the machine when the debugger attached, restored by Start
+	initial []byte
+
+	// set by Interrupt to stop a running program
+	interrupted atomic.Bool
+
+	// history of the run, for stepping backwards
+	recording *vm.Recording
+}
+
+// DefaultHistory is the number of steps that the debugger can undo.
+const DefaultHistory = 1_000_000
+
+type watch struct {
+	address int
+	value   int
+}
+
+// New resets the machine, keeping its streams, and returns a debugger for it.
+// A suspended machine, such as one restored from a snapshot, is not reset;
+// the debugger continues from where it stopped.
+func New(m *vm.VM) *Debugger {
+	d := &Debugger{
+		m:           m,
+		breakpoints: make(map[int]bool),
+		watches:     make(map[string]*watch),
+		labels:      make(map[int][]string),
+		lines:       make(map[int]int),
+	}
+	for name, address := range m.Symbols {
+		d.labels[address] = append(d.labels[address], name)
+	}
+	for address, names := range d.labels {
+		sort.Strings(names)
+		d.addresses = append(d.addresses, address)
+	}
+	sort.Ints(d.addresses)
+	for pc, w := range m.Core[:m.Registers.Last] {
+		if _, ok := d.lines[w.Source.Line]; !ok && w.Source.Line != 0 && !w.Source.Continuation {
+			d.lines[w.Source.Line] = pc
+		}
+	}
+	if m.Registers.Suspended {
+		d.attach()
+	} else {
+		d.Start()
+	}
+	return d
+}
+
+// Start returns the machine to the state it was in when the debugger
+// attached, so that the program runs again from the start, or from where
+// a restored snapshot stopped. The streams are kept; input that has been
+// read is not read again. It starts a new recording so that the run can
+// be stepped backwards.
+func (d *Debugger) Start() {
+	if d.initial == nil {
+		d.m.Reset(nil, nil)
+	} else if err := d.restore(); err != nil {
+		d.m.Reset(nil, nil)
+	}
+	d.attach()
+}
+
+// attach starts recording and saves the state needed by the debugger.
+// The first time, it takes the snapshot that Start restores.
+func (d *Debugger) attach() {
+	d.m.Registers.Suspended = false
+	if d.initial == nil {
+		b := &bytes.Buffer{}
+		if err := d.m.Snapshot(b); err == nil {
+			d.initial = b.Bytes()
+		}
+	}
+	d.recording = vm.NewRecording()
+	d.recording.Limit = DefaultHistory
+	d.m.SetRecording(d.recording)
+	d.ffBase, d.lfBase = d.m.StackBounds()
+	for _, w := range d.watches {
+		w.value = d.load(w.address)
+	}
+}
+
+// restore copies the machine state from the snapshot taken by attach.
+func (d *Debugger) restore() error {
+	m, err := vm.Restore(bytes.NewReader(d.initial))
+	if err != nil {
+		return err
+	}
+	d.m.PC, d.m.A, d.m.B, d.m.C = m.PC, m.A, m.B, m.C
+	d.m.Registers = m.Registers
+	d.m.Core = m.Core
+	d.m.RS = append(d.m.RS[:0], m.RS...)
+	return nil
+}
+
+// VM returns the machine being debugged.
+func (d *Debugger) VM() *vm.VM {
+	return d.m
+}
+
+// Reason is the reason that the machine stopped.
+type Reason int
+
+const (
+	Stepped Reason = iota
+	Breakpoint
+	Watchpoint
+	Halted
+	Quit
+	Failed
+	Paused
+	NoHistory // stepping backwards reached the start of the recording
+)
+
+// String implements the Stringer interface.
+func (r Reason) String() string {
+	switch r {
+	case Stepped:
+		return "step"
+	case Breakpoint:
+		return "breakpoint"
+	case Watchpoint:
+		return "watchpoint"
+	case Halted:
+		return "halted"
+	case Quit:
+		return "quit"
+	case Failed:
+		return "error"
+	case Paused:
+		return "pause"
+	case NoHistory:
+		return "start of history"
+	}
+	return fmt.Sprintf("reason(%d)", int(r))
+}
+
+// Stop describes why and where the machine stopped.
+type Stop struct {
+	Reason  Reason
+	PC      int
+	Changes []Change // watched variables that changed, in name order
+	Err     error    // set when the step failed
+}
+
+// Change is a change to the value of a watched variable.
+type Change struct {
+	Watch    string // name of the variable
+	Old, New int
+}
+
+// String implements the Stringer interface.
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %d -> %d", c.Watch, c.Old, c.New)
+}
+
+// Resolve returns the address for a label, a number, or a source line
+// written as "line:N" or ":N". It doesn't read the machine's memory, so it
+// is safe to call while the program is running in another goroutine.
+func (d *Debugger) Resolve(spec string) (int, error) {
+	if text, ok := strings.CutPrefix(spec, "line:"); ok {
+		return d.resolveLine(text)
+	} else if text, ok = strings.CutPrefix(spec, ":"); ok {
+		return d.resolveLine(text)
+	} else if n, err := strconv.Atoi(spec); err == nil {
+		if n < 0 || n >= len(d.m.Core) {
+			return 0, fmt.Errorf("%d: %w", n, vm.ErrAddressOutOfRange)
+		}
+		return n, nil
+	} else if address, ok := d.m.Symbols[spec]; ok {
+		return address, nil
+	}
+	return 0, fmt.Errorf("%s: %w", spec, ErrUnknownSymbol)
+}
+
+// resolveLine returns the address of the first instruction for a source line.
+func (d *Debugger) resolveLine(text string) (int, error) {
+	line, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, fmt.Errorf("line %q: %w", text, ErrBadLine)
+	}
+	if pc, ok := d.lines[line]; ok {
+		return pc, nil
+	}
+	return 0, fmt.Errorf("line %d: %w", line, ErrBadLine)
+}
+
+// Break sets a breakpoint and returns its address.
+// Like Clear and ClearAll, it may be called while the program is running.
+func (d *Debugger) Break(spec string) (int, error) {
+	pc, err := d.Resolve(spec)
+	if err != nil {
+		return 0, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.breakpoints[pc] = true
+	return pc, nil
+}
+
+// Clear removes a breakpoint and returns its address.
+func (d *Debugger) Clear(spec string) (int, error) {
+	pc, err := d.Resolve(spec)
+	if err != nil {
+		return 0, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.breakpoints[pc] {
+		return pc, fmt.Errorf("%s: %w", spec, ErrNoBreakpoint)
+	}
+	delete(d.breakpoints, pc)
+	return pc, nil
+}
+
+// ClearAll removes all breakpoints.
+func (d *Debugger) ClearAll() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.breakpoints = make(map[int]bool)
+}
+
+// Breakpoints returns the addresses of the breakpoints in order.
+func (d *Debugger) Breakpoints() []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var list []int
+	for pc := range d.breakpoints {
+		list = append(list, pc)
+	}
+	sort.Ints(list)
+	return list
+}
+
+// Watch sets a watchpoint on a named variable.
+func (d *Debugger) Watch(name string) error {
+	address, ok := d.m.Symbols[name]
+	if !ok {
+		return fmt.Errorf("%s: %w", name, ErrUnknownSymbol)
+	}
+	d.watches[name] = &watch{address: address, value: d.load(address)}
+	return nil
+}
+
+// Unwatch removes a watchpoint.
+func (d *Debugger) Unwatch(name string) error {
+	if _, ok := d.watches[name]; !ok {
+		return fmt.Errorf("%s: %w", name, ErrNoWatchpoint)
+	}
+	delete(d.watches, name)
+	return nil
+}
+
+// Watches returns the names of the watched variables in order.
+func (d *Debugger) Watches() []string {
+	var list []string
+	for name := range d.watches {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Step executes a single instruction.
+func (d *Debugger) Step() Stop {
+	err := d.m.Step(d.m.Streams.Stdout, d.m.Streams.Messages)
+	if err != nil {
+		if errors.Is(err, vm.ErrQuit) {
+			return Stop{Reason: Quit, PC: d.m.PC}
+		} else if errors.Is(err, vm.ErrHalted) {
+			return Stop{Reason: Halted, PC: d.m.PC}
+		}
+		return Stop{Reason: Failed, PC: d.m.PC, Err: err}
+	}
+	return d.checkWatches()
+}
+
+// checkWatches updates the value of every watched variable. It returns
+// a watchpoint stop with all the variables that changed, or a step stop
+// if none did.
+func (d *Debugger) checkWatches() Stop {
+	stop := Stop{Reason: Stepped, PC: d.m.PC}
+	for _, name := range d.Watches() {
+		w := d.watches[name]
+		if value := d.load(w.address); value != w.value {
+			stop.Reason = Watchpoint
+			stop.Changes = append(stop.Changes, Change{Watch: name, Old: w.value, New: value})
+			w.value = value
+		}
+	}
+	return stop
+}
+
+// StepOver executes a single instruction. If the instruction is a GOSUB,
+// it runs until the subroutine returns to the next instruction.
+func (d *Debugger) StepOver() Stop {
+	if d.m.PC < 0 || d.m.PC >= len(d.m.Core) || d.m.Core[d.m.PC].Op != op.GOSUB {
+		return d.Step()
+	}
+	next, depth := d.m.PC+1, len(d.m.RS)
+	return d.run(func() bool {
+		return len(d.m.RS) == depth && d.m.PC == next
+	})
+}
+
+// StepOut runs until the current subroutine exits.
+func (d *Debugger) StepOut() Stop {
+	depth := len(d.m.RS)
+	if depth == 0 {
+		return d.Continue()
+	}
+	return d.run(func() bool {
+		return len(d.m.RS) < depth
+	})
+}
+
+// Continue runs until the machine stops at a breakpoint or watchpoint,
+// or halts.
+func (d *Debugger) Continue() Stop {
+	return d.run(func() bool {
+		return false
+	})
+}
+
+// Recording returns the recording of the current run.
+func (d *Debugger) Recording() *vm.Recording {
+	return d.recording
+}
+
+// StepBack undoes the last instruction.
+// Output that the instruction wrote is not taken back.
+func (d *Debugger) StepBack() Stop {
+	if err := d.m.StepBack(); err != nil {
+		return Stop{Reason: NoHistory, PC: d.m.PC}
+	}
+	return d.checkWatches()
+}
+
+// ReverseContinue steps backwards until the machine reaches a breakpoint,
+// undoes a change to a watched variable, or reaches the start of the
+// recording. When it stops for a watchpoint, the PC is at the instruction
+// that last wrote the variable.
+func (d *Debugger) ReverseContinue() Stop {
+	defer d.interrupted.Store(false)
+	for {
+		if d.interrupted.Swap(false) {
+			return Stop{Reason: Paused, PC: d.m.PC}
+		}
+		stop := d.StepBack()
+		if stop.Reason != Stepped {
+			return stop
+		} else if d.isBreakpoint(d.m.PC) {
+			stop.Reason = Breakpoint
+			return stop
+		}
+	}
+}
+
+// Interrupt stops a running Continue, ReverseContinue, StepOver or StepOut
+// at the next instruction. If none is running, the next one to start stops
+// before its first instruction. It is safe to call from another goroutine.
+func (d *Debugger) Interrupt() {
+	d.interrupted.Store(true)
+}
+
+// run steps until done returns true or the machine stops for another reason.
+// A breakpoint on the first instruction is ignored so that we can continue from it.
+func (d *Debugger) run(done func() bool) Stop {
+	defer d.interrupted.Store(false)
+	for {
+		if d.interrupted.Swap(false) {
+			return Stop{Reason: Paused, PC: d.m.PC}
+		}
+		stop := d.Step()
+		if stop.Reason != Stepped {
+			return stop
+		} else if done() {
+			return stop
+		} else if d.isBreakpoint(d.m.PC) {
+			stop.Reason = Breakpoint
+			return stop
+		}
+	}
+}
+
+// isBreakpoint returns true if there is a breakpoint at pc.
+func (d *Debugger) isBreakpoint(pc int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.breakpoints[pc]
+}
+
+// Frame is an entry in the call stack.
+type Frame struct {
+	PC   int    // current instruction, or the GOSUB for callers
+	Name string // name of the subroutine, or of the program
+}
+
+// Frames returns the call stack, innermost first. The first frame is at PC
+// and each return address on RS adds a frame for the GOSUB that made the call.
+func (d *Debugger) Frames() []Frame {
+	var frames []Frame
+	pc := d.m.PC
+	for n := len(d.m.RS) - 1; n >= 0; n-- {
+		call := d.m.RS[n] - 1
+		frames = append(frames, Frame{PC: pc, Name: d.callee(call)})
+		pc = call
+	}
+	name := d.m.Name
+	if name == "" {
+		name = "main"
+	}
+	return append(frames, Frame{PC: pc, Name: name})
+}
+
+// callee returns the name of the subroutine called by the GOSUB at pc.
+func (d *Debugger) callee(pc int) string {
+	if pc < 0 || pc >= len(d.m.Core) || d.m.Core[pc].Op != op.GOSUB {
+		return "?"
+	}
+	target := d.m.Core[pc].Value
+	if 0 <= target && target < len(d.m.Core) && d.m.Core[target].Source.Op == op.SUBR {
+		return d.m.Core[target].Text
+	}
+	return d.Symbolize(target)
+}
+
+// load returns the value at an address, or zero if it is out of range.
+func (d *Debugger) load(address int) int {
+	value, _ := d.m.Load(address)
+	return value
+}
@@ -0,0 +1,242 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package debugger_test
+
+import (
+	"errors"
+	"github.com/maloquacious/ml_i/pkg/lowl/debugger"
+	"github.com/maloquacious/ml_i/pkg/lowl/op"
+	"github.com/maloquacious/ml_i/pkg/lowl/vm"
+	"testing"
+)
+
+// newMachine returns a program that calls a subroutine to count down from 3.
+func newMachine() *vm.VM {
+	m := &vm.VM{}
+	for pc, w := range []vm.Word{
+		{Op: op.HALT},                   // 0
+		{Op: op.CON},                    // 1 COUNT
+		{Op: op.LAL, Value: 3},          // 2 BEGIN
+		{Op: op.STV, Value: 1},          // 3
+		{Op: op.GOSUB, Value: 9},        // 4 LOOP
+		{Op: op.LAV, Value: 1},          // 5
+		{Op: op.CAL, Value: 0},          // 6
+		{Op: op.GONE, Value: 4},         // 7
+		{Op: op.MDCALL, Text: "MDQUIT"}, // 8
+		{Op: op.LAV, Value: 1},          // 9 DEC
+		{Op: op.SAL, Value: 1},          // 10
+		{Op: op.STV, Value: 1},          // 11
+		{Op: op.EXIT, Value: 1},         // 12
+	} {
+		w.Source.Line = pc + 1
+		m.SetWord(pc, w)
+	}
+	m.Registers.Start, m.Registers.Last = 2, 13
+	m.Symbols = map[string]int{"COUNT": 1, "BEGIN": 2, "LOOP": 4, "DEC": 9}
+	return m
+}
+
+func TestDebugger(t *testing.T) {
+	d := debugger.New(newMachine())
+	if got := d.VM().PC; got != 2 {
+		t.Fatalf("start: want pc 2: got %d\n", got)
+	}
+
+	if pc, err := d.Break("LOOP"); err != nil || pc != 4 {
+		t.Errorf("break: want 4, nil: got %d, %v\n", pc, err)
+	}
+	if pc, err := d.Break("line:12"); err != nil || pc != 11 {
+		t.Errorf("break: want 11, nil: got %d, %v\n", pc, err)
+	}
+	if _, err := d.Break("NOWHERE"); !errors.Is(err, debugger.ErrUnknownSymbol) {
+		t.Errorf("break: want unknown symbol: got %v\n", err)
+	}
+
+	if stop := d.Continue(); stop.Reason != debugger.Breakpoint || stop.PC != 4 {
+		t.Errorf("continue: want breakpoint 4: got %s %d\n", stop.Reason, stop.PC)
+	}
+	if _, err := d.Clear("line:12"); err != nil {
+		t.Errorf("clear: want nil: got %v\n", err)
+	}
+
+	// step over the call to DEC
+	if stop := d.StepOver(); stop.Reason != debugger.Stepped || stop.PC != 5 {
+		t.Errorf("next: want step 5: got %s %d\n", stop.Reason, stop.PC)
+	} else if got := d.VM().Core[1].Value; got != 2 {
+		t.Errorf("next: want COUNT 2: got %d\n", got)
+	}
+
+	// step into DEC, then out again
+	if stop := d.Continue(); stop.Reason != debugger.Breakpoint || stop.PC != 4 {
+		t.Errorf("continue: want breakpoint 4: got %s %d\n", stop.Reason, stop.PC)
+	}
+	if stop := d.Step(); stop.PC != 9 || len(d.VM().RS) != 1 {
+		t.Errorf("step: want 9 depth 1: got %d depth %d\n", stop.PC, len(d.VM().RS))
+	}
+	if stop := d.StepOut(); stop.PC != 5 || len(d.VM().RS) != 0 {
+		t.Errorf("finish: want 5 depth 0: got %d depth %d\n", stop.PC, len(d.VM().RS))
+	}
+
+	// watch COUNT change
+	d.ClearAll()
+	if err := d.Watch("COUNT"); err != nil {
+		t.Fatalf("watch: want nil: got %v\n", err)
+	}
+	if stop := d.Continue(); stop.Reason != debugger.Watchpoint || len(stop.Changes) != 1 || stop.Changes[0] != (debugger.Change{Watch: "COUNT", Old: 1, New: 0}) {
+		t.Errorf("continue: want watch COUNT 1 -> 0: got %s %v\n", stop.Reason, stop.Changes)
+	}
+	if err := d.Unwatch("COUNT"); err != nil {
+		t.Errorf("unwatch: want nil: got %v\n", err)
+	}
+	if stop := d.Continue(); stop.Reason != debugger.Quit {
+		t.Errorf("continue: want quit: got %s\n", stop.Reason)
+	}
+
+	// go backwards to where COUNT was last written, then to the start
+	if err := d.Watch("COUNT"); err != nil {
+		t.Fatalf("watch: want nil: got %v\n", err)
+	}
+	if stop := d.ReverseContinue(); stop.Reason != debugger.Watchpoint || stop.PC != 11 || len(stop.Changes) != 1 || stop.Changes[0] != (debugger.Change{Watch: "COUNT", Old: 0, New: 1}) {
+		t.Errorf("reverse: want watch at 11 COUNT 0 -> 1: got %s %d %v\n", stop.Reason, stop.PC, stop.Changes)
+	}
+	if stop := d.StepBack(); stop.Reason != debugger.Stepped || stop.PC != 10 || d.VM().A != 1 {
+		t.Errorf("back: want step 10 A 1: got %s %d A %d\n", stop.Reason, stop.PC, d.VM().A)
+	}
+	_ = d.Unwatch("COUNT")
+	if stop := d.ReverseContinue(); stop.Reason != debugger.NoHistory || stop.PC != 2 || d.VM().Core[1].Value != 0 {
+		t.Errorf("reverse: want start of history at 2 COUNT 0: got %s %d COUNT %d\n", stop.Reason, stop.PC, d.VM().Core[1].Value)
+	}
+	if stop := d.Continue(); stop.Reason != debugger.Quit {
+		t.Errorf("continue: want quit: got %s\n", stop.Reason)
+	}
+
+	if got := d.Symbolize(11); got != "DEC+2" {
+		t.Errorf("symbolize: want %q: got %q\n", "DEC+2", got)
+	}
+}
+
+// TestWatches tests that every watched variable that changes is reported.
+func TestWatches(t *testing.T) {
+	// ALIAS is a second name for COUNT, so both change on the same step
+	m := newMachine()
+	m.Symbols["ALIAS"] = 1
+	d := debugger.New(m)
+	for _, name := range []string{"COUNT", "ALIAS"} {
+		if err := d.Watch(name); err != nil {
+			t.Fatalf("watch: want nil: got %v\n", err)
+		}
+	}
+
+	for _, tc := range []struct {
+		id      int
+		back    bool
+		reason  debugger.Reason
+		pc      int
+		changes []debugger.Change
+	}{
+		{id: 1, reason: debugger.Stepped, pc: 3},
+		{id: 2, reason: debugger.Watchpoint, pc: 4, changes: []debugger.Change{{Watch: "ALIAS", Old: 0, New: 3}, {Watch: "COUNT", Old: 0, New: 3}}},
+		{id: 3, reason: debugger.Stepped, pc: 9},
+		{id: 4, back: true, reason: debugger.Stepped, pc: 4},
+		{id: 5, back: true, reason: debugger.Watchpoint, pc: 3, changes: []debugger.Change{{Watch: "ALIAS", Old: 3, New: 0}, {Watch: "COUNT", Old: 3, New: 0}}},
+		{id: 6, back: true, reason: debugger.Stepped, pc: 2},
+	} {
+		var stop debugger.Stop
+		if tc.back {
+			stop = d.StepBack()
+		} else {
+			stop = d.Step()
+		}
+		if stop.Reason != tc.reason || stop.PC != tc.pc {
+			t.Errorf("%d: want %s %d: got %s %d\n", tc.id, tc.reason, tc.pc, stop.Reason, stop.PC)
+		}
+		if len(stop.Changes) != len(tc.changes) {
+			t.Errorf("%d: changes: want %v: got %v\n", tc.id, tc.changes, stop.Changes)
+			continue
+		}
+		for i := range tc.changes {
+			if stop.Changes[i] != tc.changes[i] {
+				t.Errorf("%d: changes: want %v: got %v\n", tc.id, tc.changes, stop.Changes)
+				break
+			}
+		}
+	}
+}
+
+// TestInterrupt tests pausing the program and setting breakpoints while it runs.
+func TestInterrupt(t *testing.T) {
+	// an interrupt that arrives before the run starts is not lost
+	d := debugger.New(newMachine())
+	d.Interrupt()
+	if stop := d.Continue(); stop.Reason != debugger.Paused || stop.PC != 2 {
+		t.Errorf("continue: want pause 2: got %s %d\n", stop.Reason, stop.PC)
+	}
+	if stop := d.Continue(); stop.Reason != debugger.Quit {
+		t.Errorf("continue: want quit: got %s\n", stop.Reason)
+	}
+
+	// a program that loops forever
+	m := &vm.VM{}
+	for pc, w := range []vm.Word{
+		{Op: op.HALT},          // 0
+		{Op: op.LAL, Value: 1}, // 1 BEGIN
+		{Op: op.GO, Value: 1},  // 2
+	} {
+		w.Source.Line = pc + 1
+		m.SetWord(pc, w)
+	}
+	m.Registers.Start, m.Registers.Last = 1, 3
+	d = debugger.New(m)
+	stopped := make(chan debugger.Stop)
+	go func() {
+		stopped <- d.Continue()
+	}()
+	if pc, err := d.Break("line:3"); err != nil || pc != 2 {
+		t.Errorf("break: want 2, nil: got %d, %v\n", pc, err)
+	}
+	if stop := <-stopped; stop.Reason != debugger.Breakpoint || stop.PC != 2 {
+		t.Errorf("continue: want breakpoint 2: got %s %d\n", stop.Reason, stop.PC)
+	}
+
+	d.ClearAll()
+	go func() {
+		stopped <- d.Continue()
+	}()
+	d.Interrupt()
+	if stop := <-stopped; stop.Reason != debugger.Paused {
+		t.Errorf("continue: want pause: got %s\n", stop.Reason)
+	}
+}
+
+// TestStart tests that restarting puts back the state from when the debugger attached.
+func TestStart(t *testing.T) {
+	d := debugger.New(newMachine())
+	if _, err := d.Break("DEC"); err != nil {
+		t.Fatalf("break: want nil: got %v\n", err)
+	}
+	if stop := d.Continue(); stop.Reason != debugger.Breakpoint || stop.PC != 9 {
+		t.Fatalf("continue: want breakpoint 9: got %s %d\n", stop.Reason, stop.PC)
+	}
+	d.Start()
+	if m := d.VM(); m.PC != 2 || m.A != 0 || len(m.RS) != 0 || m.Core[1].Value != 0 {
+		t.Errorf("start: want pc 2 A 0 depth 0 COUNT 0: got pc %d A %d depth %d COUNT %d\n", m.PC, m.A, len(m.RS), m.Core[1].Value)
+	}
+	if stop := d.Continue(); stop.Reason != debugger.Breakpoint || stop.PC != 9 || d.VM().Core[1].Value != 3 {
+		t.Errorf("continue: want breakpoint 9 COUNT 3: got %s %d COUNT %d\n", stop.Reason, stop.PC, d.VM().Core[1].Value)
+	}
+
+	// a suspended machine restarts from where it was suspended
+	m := newMachine()
+	m.PC, m.A, m.Registers.Suspended = 5, 2, true
+	m.Core[1].Value = 2
+	d = debugger.New(m)
+	if stop := d.Continue(); stop.Reason != debugger.Quit {
+		t.Fatalf("continue: want quit: got %s\n", stop.Reason)
+	}
+	d.Start()
+	if m.PC != 5 || m.A != 2 || m.Core[1].Value != 2 || m.Registers.Halted {
+		t.Errorf("start: want pc 5 A 2 COUNT 2: got pc %d A %d COUNT %d halted %v\n", m.PC, m.A, m.Core[1].Value, m.Registers.Halted)
+	}
+}
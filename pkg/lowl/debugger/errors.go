@@ -0,0 +1,14 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package debugger
+
+import "fmt"
+
+var (
+	ErrBadLine       = fmt.Errorf("no instruction for line")
+	ErrNoBreakpoint  = fmt.Errorf("no breakpoint")
+	ErrNoWatchpoint  = fmt.Errorf("no watchpoint")
+	ErrUnknownSymbol = fmt.Errorf("unknown symbol")
+)
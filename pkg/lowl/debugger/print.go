@@ -0,0 +1,103 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package debugger
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Symbolize returns the address as the nearest label at or before it,
+// for example "LOOP" or "LOOP+3". It returns the number if there is no label.
+func (d *Debugger) Symbolize(address int) string {
+	n := sort.SearchInts(d.addresses, address+1) - 1
+	if n < 0 {
+		return fmt.Sprintf("%d", address)
+	}
+	base := d.addresses[n]
+	if base == address {
+		return d.labels[base][0]
+	}
+	return fmt.Sprintf("%s+%d", d.labels[base][0], address-base)
+}
+
+// Labels returns the names of the symbols at an address.
+func (d *Debugger) Labels(address int) []string {
+	return d.labels[address]
+}
+
+// Where returns a one-line description of the instruction at PC.
+func (d *Debugger) Where() string {
+	return d.Describe(d.m.PC)
+}
+
+// Describe returns a one-line description of the instruction at an address.
+func (d *Debugger) Describe(pc int) string {
+	if pc < 0 || pc >= len(d.m.Core) {
+		return fmt.Sprintf("%d: out of range", pc)
+	}
+	w := d.m.Core[pc]
+	text := fmt.Sprintf("%d [%s]: %s %d %d", pc, d.Symbolize(pc), w.Op, w.Value, w.ValueTwo)
+	if w.Source.Line != 0 {
+		text += fmt.Sprintf(" ;; line %d: %s", w.Source.Line, strings.TrimSpace(w.Source.Op.String()+" "+w.Source.Parameters))
+	}
+	return text
+}
+
+// PrintRegisters writes the registers.
+func (d *Debugger) PrintRegisters(w io.Writer) {
+	m := d.m
+	_, _ = fmt.Fprintf(w, "PC %6d [%s]\n", m.PC, d.Symbolize(m.PC))
+	_, _ = fmt.Fprintf(w, "A  %6d\n", m.A)
+	_, _ = fmt.Fprintf(w, "B  %6d\n", m.B)
+	_, _ = fmt.Fprintf(w, "%s\n", strings.TrimSpace(fmt.Sprintf("C  %6d %s", m.C, char(m.C))))
+	_, _ = fmt.Fprintf(w, "CMP    %s\n", m.Registers.Cmp)
+	_, _ = fmt.Fprintf(w, "EXIT %4d\n", m.Registers.JumpValue)
+}
+
+// PrintReturnStack writes the return stack, most recent call first.
+func (d *Debugger) PrintReturnStack(w io.Writer) {
+	if len(d.m.RS) == 0 {
+		_, _ = fmt.Fprintf(w, "return stack is empty\n")
+		return
+	}
+	for n := len(d.m.RS) - 1; n >= 0; n-- {
+		_, _ = fmt.Fprintf(w, "#%-3d %s\n", len(d.m.RS)-1-n, d.Describe(d.m.RS[n]))
+	}
+}
+
+// PrintStacks writes the forwards stack (from its base up to FFPT)
+// and the backwards stack (from LFPT up to its base).
+func (d *Debugger) PrintStacks(w io.Writer) {
+	ffpt, lfpt := d.load(d.m.Registers.FFPT), d.load(d.m.Registers.LFPT)
+	_, _ = fmt.Fprintf(w, "forwards stack: %d words (FFPT %d)\n", ffpt-d.ffBase, ffpt)
+	d.PrintMemory(w, d.ffBase, ffpt-d.ffBase)
+	_, _ = fmt.Fprintf(w, "backwards stack: %d words (LFPT %d)\n", d.lfBase-lfpt, lfpt)
+	d.PrintMemory(w, lfpt, d.lfBase-lfpt)
+}
+
+// PrintMemory writes count words starting at an address,
+// annotated with the names of any symbols at each address.
+func (d *Debugger) PrintMemory(w io.Writer, address, count int) {
+	for pc := address; pc < address+count; pc++ {
+		if pc < 0 || pc >= len(d.m.Core) {
+			_, _ = fmt.Fprintf(w, "%6d  out of range\n", pc)
+			return
+		}
+		word := d.m.Core[pc]
+		text := fmt.Sprintf("%6d  %-16s %-8s %6d %s", pc, strings.Join(d.labels[pc], ","), word.Op, word.Value, char(word.Value))
+		_, _ = fmt.Fprintf(w, "%s\n", strings.TrimRight(text, " "))
+	}
+}
+
+// char returns a printable representation of a character value.
+func char(value int) string {
+	if ' ' <= value && value <= '~' {
+		return fmt.Sprintf("%q", rune(value))
+	}
+	return ""
+}
@@ -97,6 +97,7 @@ const (
 	SUBR        // declare subroutine
 	UNSTK       // pop value from backwards stack
 	// implementation dependent op codes
+	MDCALL  // call a machine-dependent subroutine implemented in Go
 	MDERCH  // MDERCH - emit character in register C
 	MDLABEL // declare a label
 	MDQUIT  // MDQUIT - exit the program
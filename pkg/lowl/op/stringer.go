@@ -120,6 +120,8 @@ func (op Code) String() string {
 		return "LCM"
 	case LCN:
 		return "LCN"
+	case MDCALL:
+		return "MDCALL"
 	case MDERCH:
 		return "MDERCH"
 	case MDLABEL:
@@ -0,0 +1,69 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package vm
+
+import (
+	"fmt"
+)
+
+// callReturn is the return address pushed by Call. EXIT from the called
+// subroutine pops it, which ends the call before it is ever jumped to.
+const callReturn = -1
+
+// SetEntry makes the named label the start address of the program.
+// The assembler starts programs at BEGIN.
+func (m *VM) SetEntry(name string) error {
+	address, ok := m.Symbols[name]
+	if !ok {
+		return fmt.Errorf("%s: %w", name, ErrUnknownSymbol)
+	}
+	m.Registers.Start = address
+	return nil
+}
+
+// SetOverflowHandler makes the named label the address that the stacking
+// instructions branch to when the stacks collide. The assembler uses ERLSO
+// if the program defines it.
+func (m *VM) SetOverflowHandler(name string) error {
+	address, ok := m.Symbols[name]
+	if !ok {
+		return fmt.Errorf("%s: %w", name, ErrUnknownSymbol)
+	}
+	m.Registers.ERLSO = address
+	return nil
+}
+
+// Call calls the named subroutine with the default options.
+func (m *VM) Call(name string, param int) (exit int, err error) {
+	return m.CallWithOptions(name, param, RunOptions{})
+}
+
+// CallWithOptions calls the named subroutine and runs until it exits,
+// returning the exit number.
+//
+// The machine is reset as it is for a run, except that it starts at the
+// subroutine, with the parameter in register A, where SUBR expects it,
+// and a return address on the return stack. The call ends when the
+// subroutine exits through that address. If the machine halts or quits
+// first, the error is ErrHalted or ErrQuit. A call that stops because of
+// its budget or context can't be resumed.
+func (m *VM) CallWithOptions(name string, param int, opts RunOptions) (exit int, err error) {
+	address, ok := m.Symbols[name]
+	if !ok {
+		return 0, fmt.Errorf("%s: %w", name, ErrUnknownSymbol)
+	}
+	m.Reset(nil, nil)
+	m.PC, m.A = address, param
+	m.Registers.JumpValue = 0
+	m.RS = append(m.RS, callReturn)
+
+	err = m.loop(opts, 1)
+	m.Registers.Suspended = false
+	if err = m.coreOnError(opts, err); err != nil {
+		return 0, err
+	}
+	m.PC = m.Registers.Start
+	return m.Registers.JumpValue, nil
+}
@@ -0,0 +1,83 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package vm_test
+
+import (
+	"errors"
+	"github.com/maloquacious/ml_i/pkg/lowl/op"
+	"github.com/maloquacious/ml_i/pkg/lowl/vm"
+	"testing"
+)
+
+// TestCall tests calling subroutines from Go.
+func TestCall(t *testing.T) {
+	m := vm.New()
+	parnm := m.Registers.PARNM
+	m.Symbols = map[string]int{"BEGIN": 40, "DOUBLE": 10, "TWICE": 20, "STOP": 30}
+	// SUBR DOUBLE,PARNM,2 doubles the parameter and takes exit 2 if it is 10 or more
+	m.SetWord(10, vm.Word{Op: op.STV, Value: parnm})
+	m.SetWord(11, vm.Word{Op: op.LAV, Value: parnm})
+	m.SetWord(12, vm.Word{Op: op.AAV, Value: parnm})
+	m.SetWord(13, vm.Word{Op: op.CAL, Value: 10})
+	m.SetWord(14, vm.Word{Op: op.GOGE, Value: 16})
+	m.SetWord(15, vm.Word{Op: op.EXIT, Value: 1})
+	m.SetWord(16, vm.Word{Op: op.EXIT, Value: 2})
+	// SUBR TWICE,X,1 calls DOUBLE twice
+	m.SetWord(20, vm.Word{Op: op.NOOP})
+	m.SetWord(21, vm.Word{Op: op.GOSUB, Value: 10})
+	m.SetWord(22, vm.Word{Op: op.GOSUB, Value: 10})
+	m.SetWord(23, vm.Word{Op: op.EXIT, Value: 1})
+	// SUBR STOP,X,1 halts
+	m.SetWord(30, vm.Word{Op: op.HALT})
+	m.SetWord(40, vm.Word{Op: op.HALT})
+
+	for _, tc := range []struct {
+		name  string
+		param int
+		exit  int
+		a     int
+	}{
+		{name: "DOUBLE", param: 3, exit: 1, a: 6},
+		{name: "DOUBLE", param: 7, exit: 2, a: 14},
+		{name: "TWICE", param: 3, exit: 1, a: 12},
+	} {
+		exit, err := m.Call(tc.name, tc.param)
+		if err != nil {
+			t.Fatalf("%s(%d): want nil: got %v\n", tc.name, tc.param, err)
+		} else if exit != tc.exit || m.A != tc.a {
+			t.Errorf("%s(%d): want exit %d A %d: got exit %d A %d\n", tc.name, tc.param, tc.exit, tc.a, exit, m.A)
+		}
+		if len(m.RS) != 0 {
+			t.Errorf("%s(%d): want empty return stack: got %v\n", tc.name, tc.param, m.RS)
+		}
+	}
+
+	if _, err := m.Call("STOP", 0); !errors.Is(err, vm.ErrHalted) {
+		t.Errorf("halt: want halted: got %v\n", err)
+	}
+	if _, err := m.Call("MISSING", 0); !errors.Is(err, vm.ErrUnknownSymbol) {
+		t.Errorf("missing: want unknown symbol: got %v\n", err)
+	}
+
+	// the entry label replaces BEGIN as the start address
+	if err := m.SetEntry("DOUBLE"); err != nil {
+		t.Fatalf("entry: want nil: got %v\n", err)
+	} else if m.Registers.Start != 10 {
+		t.Errorf("entry: want start 10: got %d\n", m.Registers.Start)
+	}
+	if err := m.SetEntry("MISSING"); !errors.Is(err, vm.ErrUnknownSymbol) {
+		t.Errorf("entry: missing: want unknown symbol: got %v\n", err)
+	}
+
+	// the overflow handler is set by name
+	if err := m.SetOverflowHandler("STOP"); err != nil {
+		t.Fatalf("erlso: want nil: got %v\n", err)
+	} else if m.Registers.ERLSO != 30 {
+		t.Errorf("erlso: want 30: got %d\n", m.Registers.ERLSO)
+	}
+	if err := m.SetOverflowHandler("MISSING"); !errors.Is(err, vm.ErrUnknownSymbol) {
+		t.Errorf("erlso: missing: want unknown symbol: got %v\n", err)
+	}
+}
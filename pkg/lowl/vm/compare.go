@@ -15,6 +15,12 @@ const (
 	IS_GR CMPRSLT = 1  // register is greater than value
 )
 
+// CompareAddresses is the ValueTwo of a CAV or CAI word assembled with the
+// A flag. It compares the operands as unsigned addresses instead of as
+// signed numbers.
+const CompareAddresses = 1
+
+// compare sets the comparison register from a signed comparison of r and v.
 func (m *VM) compare(r, v int) {
 	if r < v {
 		m.Registers.Cmp = IS_LT
@@ -25,6 +31,28 @@ func (m *VM) compare(r, v int) {
 	}
 }
 
+// compareAddresses sets the comparison register from an unsigned
+// comparison of r and v in the machine's word size.
+func (m *VM) compareAddresses(r, v int) {
+	if ur, uv := m.Unsigned(r), m.Unsigned(v); ur < uv {
+		m.Registers.Cmp = IS_LT
+	} else if ur == uv {
+		m.Registers.Cmp = IS_EQ
+	} else {
+		m.Registers.Cmp = IS_GR
+	}
+}
+
+// Unsigned returns the value as an unsigned number in the machine's word size,
+// which is how addresses are compared. Unbounded words are read as unsigned
+// 64-bit numbers.
+func (m *VM) Unsigned(value int) uint64 {
+	if m.Registers.WordSize != 0 {
+		return uint64(value) & (1<<m.Registers.WordSize - 1)
+	}
+	return uint64(value)
+}
+
 // String implements the Stringer interface.
 func (r CMPRSLT) String() string {
 	switch r {
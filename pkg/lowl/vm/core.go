@@ -0,0 +1,91 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package vm
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// CoreVersion is the version of the core file format written by WriteCore.
+const CoreVersion = 1
+
+// coreMagic starts every core file.
+const coreMagic = "LOWLCORE"
+
+// CoreDump is the state of a machine that failed.
+type CoreDump struct {
+	Err     string // the error that stopped the machine
+	PC      int    // address of the failing instruction
+	Machine *VM    // the machine when it failed
+}
+
+// coreHeader follows the magic string. The machine follows it as a snapshot.
+type coreHeader struct {
+	Version int
+	Err     string
+	PC      int
+}
+
+// WriteCore writes the machine and the error that stopped it to w.
+// The machine is written as a snapshot, so it includes the registers,
+// the return stack, both stacks and the contents of Core.
+func (m *VM) WriteCore(w io.Writer, err error) error {
+	header := coreHeader{Version: CoreVersion, PC: m.PC}
+	if err != nil {
+		header.Err = err.Error()
+	}
+	var re *RuntimeError
+	if errors.As(err, &re) {
+		header.PC = re.PC
+	}
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(coreMagic); err != nil {
+		return err
+	} else if err = gob.NewEncoder(bw).Encode(header); err != nil {
+		return err
+	} else if err = m.Snapshot(bw); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// writeCoreFile writes the core file for a failed run.
+func (m *VM) writeCoreFile(name string, err error) error {
+	fp, cerr := os.Create(name)
+	if cerr != nil {
+		return cerr
+	}
+	cerr = m.WriteCore(fp, err)
+	if ferr := fp.Close(); cerr == nil {
+		cerr = ferr
+	}
+	return cerr
+}
+
+// ReadCore reads a core file written by WriteCore.
+func ReadCore(r io.Reader) (*CoreDump, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(coreMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != coreMagic {
+		return nil, ErrBadCore
+	}
+	var header coreHeader
+	if err := gob.NewDecoder(br).Decode(&header); err != nil {
+		return nil, fmt.Errorf("header: %v: %w", err, ErrBadCore)
+	} else if header.Version != CoreVersion {
+		return nil, fmt.Errorf("version %d: want %d: %w", header.Version, CoreVersion, ErrBadCore)
+	}
+	m, err := Restore(br)
+	if err != nil {
+		return nil, fmt.Errorf("machine: %w", err)
+	}
+	return &CoreDump{Err: header.Err, PC: header.PC, Machine: m}, nil
+}
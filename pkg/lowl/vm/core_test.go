@@ -0,0 +1,65 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package vm_test
+
+import (
+	"errors"
+	"github.com/maloquacious/ml_i/pkg/lowl/op"
+	"github.com/maloquacious/ml_i/pkg/lowl/vm"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCore tests that a failed run writes a core file that can be read back.
+func TestCore(t *testing.T) {
+	// call a subroutine that loads from an address outside of Core
+	m := &vm.VM{Name: "CORE"}
+	m.SetWord(0, vm.Word{Op: op.LAL, Value: 7})
+	m.SetWord(1, vm.Word{Op: op.GOSUB, Value: 3})
+	m.SetWord(2, vm.Word{Op: op.MDCALL, Text: "MDQUIT"})
+	m.SetWord(3, vm.Word{Op: op.LBV, Value: vm.MAX_WORDS})
+	m.Core[3].Source.Line = 4
+
+	name := filepath.Join(t.TempDir(), "core")
+	if err := m.RunWithOptions(nil, nil, vm.RunOptions{CoreFile: name}); !errors.Is(err, vm.ErrAddressOutOfRange) {
+		t.Fatalf("run: want address out of range: got %v\n", err)
+	}
+	fp, err := os.Open(name)
+	if err != nil {
+		t.Fatalf("core: want file: got %v\n", err)
+	}
+	defer func() { _ = fp.Close() }()
+	core, err := vm.ReadCore(fp)
+	if err != nil {
+		t.Fatalf("read: want nil: got %v\n", err)
+	}
+	if core.PC != 3 || !strings.Contains(core.Err, "address out of range") {
+		t.Errorf("core: want pc 3 address out of range: got %d %q\n", core.PC, core.Err)
+	}
+	if c := core.Machine; c.Name != "CORE" || c.A != 7 || len(c.RS) != 1 || c.RS[0] != 2 || c.Core[3].Source.Line != 4 {
+		t.Errorf("core: want CORE A 7 RS [2]: got %s A %d RS %v\n", c.Name, c.A, c.RS)
+	}
+
+	// a core file that can't be written is reported with the runtime error
+	name = filepath.Join(t.TempDir(), "missing", "core")
+	if err := m.RunWithOptions(nil, nil, vm.RunOptions{CoreFile: name}); !errors.Is(err, vm.ErrAddressOutOfRange) || !errors.Is(err, vm.ErrCoreFile) {
+		t.Errorf("run: want address out of range and core file not written: got %v\n", err)
+	}
+
+	// a run that quits does not write a core file
+	name = filepath.Join(t.TempDir(), "core")
+	m.SetWord(3, vm.Word{Op: op.EXIT, Value: 1})
+	if err := m.RunWithOptions(nil, nil, vm.RunOptions{CoreFile: name}); err != nil {
+		t.Fatalf("run: want nil: got %v\n", err)
+	} else if _, err = os.Stat(name); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("core: want no file: got %v\n", err)
+	}
+
+	if _, err := vm.ReadCore(strings.NewReader("LOWLSNAP")); !errors.Is(err, vm.ErrBadCore) {
+		t.Errorf("read: want bad core: got %v\n", err)
+	}
+}
@@ -0,0 +1,212 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package vm
+
+import (
+	"fmt"
+	"github.com/maloquacious/ml_i/pkg/lowl/op"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Coverage counts the number of times each address in Core is executed.
+type Coverage struct {
+	Hits map[int]int
+}
+
+// NewCoverage returns an empty coverage.
+func NewCoverage() *Coverage {
+	return &Coverage{Hits: make(map[int]int)}
+}
+
+// SetCoverage counts every step in c. A nil c stops collecting coverage.
+func (m *VM) SetCoverage(c *Coverage) {
+	m.coverage = c
+}
+
+// IsCode returns true if the word at address is an instruction from the
+// source. Data, continuation words and the padding before the program are
+// not instructions and are not counted as missed.
+func (m *VM) IsCode(address int) bool {
+	if address < 0 || address >= len(m.Core) {
+		return false
+	}
+	w := m.Core[address]
+	if w.Source.Line == 0 || w.Source.Continuation {
+		return false
+	}
+	switch w.Source.Op {
+	case op.CON, op.DCL, op.NCH, op.STR, op.PRGEN:
+		return false
+	}
+	return true
+}
+
+// last returns the address after the last word to report on.
+func (c *Coverage) last(m *VM) int {
+	last := m.Registers.Last
+	for address := range c.Hits {
+		if address >= last {
+			last = address + 1
+		}
+	}
+	if last > len(m.Core) {
+		last = len(m.Core)
+	}
+	return last
+}
+
+// Summary returns the number of instructions executed and the number of instructions.
+func (c *Coverage) Summary(m *VM) (covered, total int) {
+	for address := 0; address < c.last(m); address++ {
+		if m.IsCode(address) {
+			total++
+			if c.Hits[address] != 0 {
+				covered++
+			}
+		}
+	}
+	return covered, total
+}
+
+// Lines returns the hit count for each source line that has instructions.
+// A line that was never executed has a count of zero.
+func (c *Coverage) Lines(m *VM) map[int]int {
+	lines := make(map[int]int)
+	for address := 0; address < c.last(m); address++ {
+		if m.IsCode(address) {
+			lines[m.Core[address].Source.Line] += c.Hits[address]
+		}
+	}
+	return lines
+}
+
+// coverageRow is one word in a coverage report.
+type coverageRow struct {
+	Hits   string
+	Class  string // hit, miss or data
+	Line   int
+	PC     int
+	Labels []string
+	Op     string
+	Value  int
+	Value2 int
+	Source string
+}
+
+// rows returns the words of the program with their hit counts.
+func (c *Coverage) rows(m *VM) []coverageRow {
+	labels := make(map[int][]string)
+	for name, address := range m.Symbols {
+		labels[address] = append(labels[address], name)
+	}
+	for address := range labels {
+		sort.Strings(labels[address])
+	}
+
+	var rows []coverageRow
+	for pc, w := range m.Core[:c.last(m)] {
+		if w.Source.Continuation {
+			continue
+		}
+		row := coverageRow{
+			Hits:   "-",
+			Class:  "data",
+			Line:   w.Source.Line,
+			PC:     pc,
+			Labels: labels[pc],
+			Op:     w.Op.String(),
+			Value:  w.Value,
+			Value2: w.ValueTwo,
+			Source: strings.TrimSpace(fmt.Sprintf("%-8s %s", w.Source.Op, w.Source.Parameters)),
+		}
+		if m.IsCode(pc) {
+			if hits := c.Hits[pc]; hits == 0 {
+				row.Hits, row.Class = "#####", "miss"
+			} else {
+				row.Hits, row.Class = fmt.Sprintf("%d", hits), "hit"
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// WriteListing writes the assembly listing with the number of times each
+// instruction was executed. Instructions that never ran are marked #####.
+func (c *Coverage) WriteListing(w io.Writer, m *VM) error {
+	covered, total := c.Summary(m)
+	if _, err := fmt.Fprintf(w, "coverage: %d of %d instructions (%s)\n", covered, total, percentOf(covered, total)); err != nil {
+		return err
+	}
+	for _, row := range c.rows(m) {
+		for n, label := range row.Labels {
+			if n == 0 {
+				_, _ = fmt.Fprintf(w, "%8s %4d %4d [%s]\n", "", row.Line, row.PC, label)
+			} else {
+				_, _ = fmt.Fprintf(w, "%8s %4s %4s [%s]\n", "", "", "", label)
+			}
+		}
+		line, pc := fmt.Sprintf("%4d", row.Line), fmt.Sprintf("%4d", row.PC)
+		if len(row.Labels) != 0 {
+			line, pc = "", ""
+		}
+		_, err := fmt.Fprintf(w, "%8s %4s %4s %-8s %6d %6d ;; %s\n", row.Hits, line, pc, row.Op, row.Value, row.Value2, row.Source)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteHTML writes the coverage listing as an HTML page with the
+// instructions that never ran highlighted.
+func (c *Coverage) WriteHTML(w io.Writer, m *VM, title string) error {
+	covered, total := c.Summary(m)
+	return coverageTemplate.Execute(w, struct {
+		Title   string
+		Covered int
+		Total   int
+		Percent string
+		Rows    []coverageRow
+	}{title, covered, total, percentOf(covered, total), c.rows(m)})
+}
+
+func percentOf(n, total int) string {
+	if total == 0 {
+		return "0.0%"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(total))
+}
+
+var coverageTemplate = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} coverage</title>
+<style>
+body { font-family: monospace; }
+table { border-collapse: collapse; }
+td { padding: 0 0.5em; white-space: pre; }
+td.n { text-align: right; }
+tr.hit { background: #dfd; }
+tr.miss { background: #fdd; }
+tr.data { color: #888; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Covered}} of {{.Total}} instructions executed ({{.Percent}})</p>
+<table>
+<tr><th>hits</th><th>line</th><th>pc</th><th>label</th><th>op</th><th>value</th><th>value2</th><th>source</th></tr>
+{{- range .Rows}}
+<tr class="{{.Class}}"><td class="n">{{.Hits}}</td><td class="n">{{.Line}}</td><td class="n">{{.PC}}</td><td>{{range $n, $l := .Labels}}{{if $n}} {{end}}[{{$l}}]{{end}}</td><td>{{.Op}}</td><td class="n">{{.Value}}</td><td class="n">{{.Value2}}</td><td>{{.Source}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
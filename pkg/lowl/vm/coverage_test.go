@@ -0,0 +1,61 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package vm_test
+
+import (
+	"bytes"
+	"github.com/maloquacious/ml_i/pkg/lowl/op"
+	"github.com/maloquacious/ml_i/pkg/lowl/vm"
+	"strings"
+	"testing"
+)
+
+// TestCoverage tests the hit counts and the listing and HTML reports.
+func TestCoverage(t *testing.T) {
+	m := &vm.VM{}
+	for pc, w := range []vm.Word{
+		{Op: op.CAL, Value: 0},          // 0
+		{Op: op.GOEQ, Value: 3},         // 1
+		{Op: op.AAL, Value: 1},          // 2 never executed
+		{Op: op.MDCALL, Text: "MDQUIT"}, // 3 DONE
+		{Op: op.DCL},                    // 4 data
+	} {
+		w.Source.Line, w.Source.Op = pc+1, w.Op
+		m.SetWord(pc, w)
+	}
+	m.Core[3].Source.Op, m.Core[4].Source.Op = op.GOSUB, op.DCL
+	m.Registers.Last = 5
+	m.Symbols = map[string]int{"DONE": 3}
+
+	c := vm.NewCoverage()
+	m.SetCoverage(c)
+	if err := m.Run(nil, nil); err != nil {
+		t.Fatalf("run: want nil: got %v\n", err)
+	}
+	if covered, total := c.Summary(m); covered != 3 || total != 4 {
+		t.Errorf("summary: want 3 of 4: got %d of %d\n", covered, total)
+	}
+	if lines := c.Lines(m); len(lines) != 4 || lines[3] != 0 || lines[4] != 1 {
+		t.Errorf("lines: want 4 lines, line 3 missed: got %v\n", lines)
+	}
+
+	b := &bytes.Buffer{}
+	if err := c.WriteListing(b, m); err != nil {
+		t.Fatalf("listing: want nil: got %v\n", err)
+	}
+	listing := strings.Split(b.String(), "\n")
+	for n, want := range []string{"3 of 4", "1    1    0 CAL", "1    2    1 GOEQ", "#####    3    2 AAL", "[DONE]", "1           MDCALL", "-    5    4 DCL"} {
+		if !strings.Contains(listing[n], want) {
+			t.Errorf("listing %d: want %q: got %q\n", n, want, listing[n])
+		}
+	}
+
+	b.Reset()
+	if err := c.WriteHTML(b, m, "test"); err != nil {
+		t.Fatalf("html: want nil: got %v\n", err)
+	} else if got := strings.Count(b.String(), `<tr class="miss">`); got != 1 {
+		t.Errorf("html: want 1 missed row: got %d\n", got)
+	}
+}
@@ -7,11 +7,58 @@ package vm
 import "fmt"
 
 var (
-	ErrCycles         = fmt.Errorf("too many cycles")
-	ErrHalted         = fmt.Errorf("halted")
-	ErrInvalidOp      = fmt.Errorf("invalid op")
-	ErrNotImplemented = fmt.Errorf("not implemented")
-	ErrQuit           = fmt.Errorf("quit")
-	ErrStackOverflow  = fmt.Errorf("stack overflow")
-	ErrStackUnderflow = fmt.Errorf("stack underflow")
+	ErrAddressOutOfRange    = fmt.Errorf("address out of range")
+	ErrBadCore              = fmt.Errorf("not a core file")
+	ErrBadRecording         = fmt.Errorf("not a recording")
+	ErrBadSnapshot          = fmt.Errorf("not a snapshot")
+	ErrCoreFile             = fmt.Errorf("core file not written")
+	ErrCycles               = fmt.Errorf("too many cycles")
+	ErrHalted               = fmt.Errorf("halted")
+	ErrInvalidOp            = fmt.Errorf("invalid op")
+	ErrNoHistory            = fmt.Errorf("no recorded history")
+	ErrNotImplemented       = fmt.Errorf("not implemented")
+	ErrOutputLimit          = fmt.Errorf("output limit exceeded")
+	ErrQuit                 = fmt.Errorf("quit")
+	ErrReturnStackOverflow  = fmt.Errorf("return stack overflow")
+	ErrReturnStackUnderflow = fmt.Errorf("return stack underflow")
+	ErrSnapshotVersion      = fmt.Errorf("unsupported snapshot version")
+	ErrStackOverflow        = fmt.Errorf("stack overflow")
+	ErrStackUnderflow       = fmt.Errorf("stack underflow")
+	ErrUnknownStream        = fmt.Errorf("unknown stream")
+	ErrUnknownSymbol        = fmt.Errorf("unknown symbol")
+	ErrWordSize             = fmt.Errorf("unsupported word size")
 )
+
+// RuntimeError is returned by Step when an instruction fails.
+// It records the failing instruction and a snapshot of the registers.
+type RuntimeError struct {
+	PC      int     // address of the failing instruction
+	Word    Word    // the failing instruction, including its source
+	A, B, C int     // registers after the failure
+	Cmp     CMPRSLT // comparison register
+	RS      []int   // copy of the return stack
+	Err     error   // the underlying error, usually one of the sentinels
+}
+
+// Error implements the error interface.
+func (e *RuntimeError) Error() string {
+	if e.Word.Source.Line == 0 {
+		return fmt.Sprintf("%d: %s: %v", e.PC, e.Word.Op, e.Err)
+	}
+	return fmt.Sprintf("%d: line %d: %s: %v", e.PC, e.Word.Source.Line, e.Word.Source.Op, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// runtimeError returns a RuntimeError for the instruction at pc.
+func (m *VM) runtimeError(pc int, err error) *RuntimeError {
+	e := &RuntimeError{PC: pc, A: m.A, B: m.B, C: m.C, Cmp: m.Registers.Cmp, Err: err}
+	if 0 <= pc && pc < len(m.Core) {
+		e.Word = m.Core[pc]
+	}
+	e.RS = append(e.RS, m.RS...)
+	return e
+}
@@ -9,29 +9,132 @@ import (
 	"io"
 )
 
+// addressFault is raised (as a panic) by the memory helpers when an
+// address is outside of Core. Step recovers it and returns an error.
+type addressFault struct {
+	address int
+}
+
+// address returns the address that a word holds.
+// With a bounded word size, a negative address is read as unsigned.
+func (m *VM) address(address int) int {
+	if address < 0 && m.Registers.WordSize != 0 {
+		address &= 1<<m.Registers.WordSize - 1
+	}
+	return address
+}
+
+// check raises an addressFault if the address is outside of Core.
+func (m *VM) check(address int) int {
+	address = m.address(address)
+	if address < 0 || address >= len(m.Core) {
+		panic(addressFault{address: address})
+	}
+	return address
+}
+
+// checkRange raises an addressFault if any address in the range is outside of Core.
+func (m *VM) checkRange(address, length int) {
+	if length < 0 {
+		panic(addressFault{address: address + length})
+	} else if length > 0 {
+		m.check(address)
+		m.check(address + length - 1)
+	}
+}
+
 // directLoad returns the value of variable v
 func (m *VM) directLoad(v int) int {
-	return m.Core[v].Value
+	return m.wrap(m.Core[m.check(v)].Value)
 }
 
 // directStore saves the value into variable v
 func (m *VM) directStore(v, value int) {
+	v, value = m.check(v), m.wrap(value)
+	m.saveWord(v)
 	m.Core[v].Value = value
+	m.noteWrite(v, value)
 }
 
 // indexedLoad returns the contents of the address pointed to by B + n
 func (m *VM) indexedLoad(n int) int {
-	return m.Core[m.B+n].Value
+	return m.wrap(m.Core[m.check(m.B+n)].Value)
 }
 
 // indirectLoad returns the contents of the address pointed to by V
 func (m *VM) indirectLoad(v int) int {
-	return m.Core[m.Core[v].Value].Value
+	return m.wrap(m.Core[m.check(m.directLoad(v))].Value)
 }
 
 // indirectStore saves the value into the address pointed to by v
 func (m *VM) indirectStore(v, value int) {
-	m.Core[m.Core[v].Value].Value = value
+	address, value := m.check(m.directLoad(v)), m.wrap(value)
+	m.saveWord(address)
+	m.Core[address].Value = value
+	m.noteWrite(address, value)
+}
+
+// Load returns the value of the word at the address.
+// It is intended for MD subroutines.
+func (m *VM) Load(address int) (int, error) {
+	address = m.address(address)
+	if address < 0 || address >= len(m.Core) {
+		return 0, fmt.Errorf("address %d: %w", address, ErrAddressOutOfRange)
+	}
+	return m.wrap(m.Core[address].Value), nil
+}
+
+// Store saves the value into the word at the address.
+// It is intended for MD subroutines.
+func (m *VM) Store(address, value int) error {
+	address = m.address(address)
+	if address < 0 || address >= len(m.Core) {
+		return fmt.Errorf("address %d: %w", address, ErrAddressOutOfRange)
+	}
+	value = m.wrap(value)
+	m.saveWord(address)
+	m.Core[address].Value = value
+	m.noteWrite(address, value)
+	return nil
+}
+
+// Push saves the value into the word at the address, as Store does, and
+// reports it to the OnPush hook as a value stored on the given stack.
+// It is intended for MD subroutines that stack values.
+func (m *VM) Push(stack StackKind, address, value int) error {
+	if err := m.Store(address, value); err != nil {
+		return err
+	}
+	m.pushed(stack, address, value)
+	return nil
+}
+
+// readChar returns the next character from the current input stream or EOF.
+// When recording, characters undone by StepBack are returned again first.
+func (m *VM) readChar() int {
+	r := m.recording
+	if r != nil {
+		if read, ok := r.replayChar(); ok {
+			if read.Char != EOF && 0 <= read.Stream && read.Stream < len(m.Streams.Inputs) {
+				m.Streams.Inputs[read.Stream].Offset++
+			}
+			r.noteRead(read, true)
+			return read.Char
+		}
+	}
+	ch := EOF
+	if m.Streams.Stdin != nil {
+		if b, err := m.Streams.Stdin.ReadByte(); err == nil {
+			ch = int(b)
+			if n := m.Streams.Input; 0 <= n && n < len(m.Streams.Inputs) && m.Streams.Inputs[n].Reader == m.Streams.Stdin {
+				m.Streams.Inputs[n].Offset++
+			}
+		}
+	}
+	if r != nil {
+		r.noteRead(Read{Stream: m.Streams.Input, Char: ch}, false)
+	}
+	return ch
 }
 
 func printf(w io.Writer, format string, args ...any) {
@@ -0,0 +1,93 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package vm
+
+import (
+	"errors"
+	"io"
+)
+
+// Hooks are functions called by the machine as it runs, for programs that
+// embed the VM. Any of the functions may be nil. When VM.Hooks is nil, the
+// machine only pays for a nil check.
+//
+// The hooks are called while the machine is in the middle of a step, so
+// they should not change the machine.
+type Hooks struct {
+	// OnStep is called before the instruction at pc is executed.
+	OnStep func(m *VM, pc int, w Word)
+	// OnCall is called after GOSUB at pc has pushed its return address,
+	// with the address of the subroutine and the parameter in register A.
+	// Calls to MD subroutines are not reported.
+	OnCall func(m *VM, pc, target, param int)
+	// OnExit is called after EXIT at pc has returned, with the exit number.
+	OnExit func(m *VM, pc, exit int)
+	// OnOutput is called after text is written to the current output
+	// stream (message is false) or to the message stream (message is true).
+	OnOutput func(m *VM, text string, message bool)
+	// OnPush is called after a value is stored on one of the stacks,
+	// with the address it was stored at.
+	OnPush func(m *VM, stack StackKind, address, value int)
+	// OnPop is called after a value is taken off one of the stacks,
+	// with the address it was taken from.
+	OnPop func(m *VM, stack StackKind, address, value int)
+	// OnHalt is called when the machine stops, with ErrHalted or ErrQuit.
+	OnHalt func(m *VM, err error)
+}
+
+// StackKind says which of the two stacks a value was pushed or popped on.
+type StackKind int
+
+const (
+	ForwardsStack StackKind = iota
+	BackwardsStack
+)
+
+func (k StackKind) String() string {
+	if k == BackwardsStack {
+		return "backwards"
+	}
+	return "forwards"
+}
+
+// output writes text to w and reports it to the OnOutput hook.
+func (m *VM) output(w io.Writer, text string, message bool) {
+	printf(w, "%s", text)
+	if h := m.Hooks; h != nil && h.OnOutput != nil {
+		h.OnOutput(m, text, message)
+	}
+}
+
+// pushed reports a value stored on a stack to the OnPush hook.
+func (m *VM) pushed(stack StackKind, address, value int) {
+	if h := m.Hooks; h != nil && h.OnPush != nil {
+		h.OnPush(m, stack, address, value)
+	}
+}
+
+// popped reports a value taken off a stack to the OnPop hook.
+func (m *VM) popped(stack StackKind, address, value int) {
+	if h := m.Hooks; h != nil && h.OnPop != nil {
+		h.OnPop(m, stack, address, value)
+	}
+}
+
+// before calls the OnStep hook before the instruction at pc.
+func (h *Hooks) before(m *VM, pc int) {
+	if h.OnStep != nil {
+		var w Word
+		if 0 <= pc && pc < len(m.Core) {
+			w = m.Core[pc]
+		}
+		h.OnStep(m, pc, w)
+	}
+}
+
+// after calls the OnHalt hook if the step stopped the machine.
+func (h *Hooks) after(m *VM, err error) {
+	if h.OnHalt != nil && err != nil && (errors.Is(err, ErrHalted) || errors.Is(err, ErrQuit)) {
+		h.OnHalt(m, err)
+	}
+}
@@ -0,0 +1,87 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package vm_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/maloquacious/ml_i/pkg/lowl/op"
+	"github.com/maloquacious/ml_i/pkg/lowl/vm"
+	"strings"
+	"testing"
+)
+
+// TestHooks tests that the hooks are called for each kind of event.
+func TestHooks(t *testing.T) {
+	m := &vm.VM{}
+	m.Registers.FFPT, m.Registers.LFPT, m.Registers.LNM = 100, 101, 1
+	m.SetWord(100, vm.Word{Op: op.DCL, Value: 200})
+	m.SetWord(101, vm.Word{Op: op.DCL, Value: 300})
+	m.SetWord(0, vm.Word{Op: op.LAL, Value: 7})
+	m.SetWord(1, vm.Word{Op: op.GOSUB, Value: 10})
+	m.SetWord(2, vm.Word{Op: op.FSTK})
+	m.SetWord(3, vm.Word{Op: op.BSTK})
+	m.SetWord(4, vm.Word{Op: op.UNSTK, Value: 102})
+	m.SetWord(5, vm.Word{Op: op.MESS, Text: "ok$"})
+	m.SetWord(6, vm.Word{Op: op.HALT})
+	m.SetWord(10, vm.Word{Op: op.LCN, Value: 'x'})
+	m.SetWord(11, vm.Word{Op: op.MDCALL, Text: "MDOUT"})
+	m.SetWord(12, vm.Word{Op: op.EXIT, Value: 1})
+
+	var events []string
+	var steps []int
+	m.Hooks = &vm.Hooks{
+		OnStep: func(m *vm.VM, pc int, w vm.Word) {
+			steps = append(steps, pc)
+		},
+		OnCall: func(m *vm.VM, pc, target, param int) {
+			events = append(events, fmt.Sprintf("call %d %d %d", pc, target, param))
+		},
+		OnExit: func(m *vm.VM, pc, exit int) {
+			events = append(events, fmt.Sprintf("exit %d %d", pc, exit))
+		},
+		OnOutput: func(m *vm.VM, text string, message bool) {
+			events = append(events, fmt.Sprintf("output %q %v", text, message))
+		},
+		OnPush: func(m *vm.VM, stack vm.StackKind, address, value int) {
+			events = append(events, fmt.Sprintf("push %s %d %d", stack, address, value))
+		},
+		OnPop: func(m *vm.VM, stack vm.StackKind, address, value int) {
+			events = append(events, fmt.Sprintf("pop %s %d %d", stack, address, value))
+		},
+		OnHalt: func(m *vm.VM, err error) {
+			events = append(events, fmt.Sprintf("halt %v", err))
+		},
+	}
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	var err error
+	for n := 0; n < 20 && err == nil; n++ {
+		err = m.Step(stdout, stderr)
+	}
+	if !errors.Is(err, vm.ErrHalted) {
+		t.Fatalf("run: want halted: got %v\n", err)
+	}
+	want := []string{
+		"call 1 10 7",
+		`output "x" false`,
+		"exit 12 1",
+		"push forwards 200 7",
+		"push backwards 299 201",
+		"pop backwards 299 201",
+		`output "ok\n" true`,
+		"halt halted",
+	}
+	if got := strings.Join(events, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("events: want\n%s\ngot\n%s\n", strings.Join(want, "\n"), got)
+	}
+	if got := fmt.Sprint(steps); got != "[0 1 10 11 12 2 3 4 5 6]" {
+		t.Errorf("steps: want [0 1 10 11 12 2 3 4 5 6]: got %s\n", got)
+	}
+	if stdout.String() != "x" || stderr.String() != "ok\n" {
+		t.Errorf("output: want %q %q: got %q %q\n", "x", "ok\n", stdout.String(), stderr.String())
+	}
+}
@@ -0,0 +1,109 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package vm
+
+import (
+	"io"
+	"sync"
+)
+
+// MDFunc implements a machine-dependent (MD) subroutine in Go.
+//
+// It is called when the program executes a GOSUB to a registered name.
+// The function may read and update the registers and Core, and is passed
+// the same writers as Step. It returns the exit number that the caller's
+// GOTBL table should take, just as the exit number of an EXIT instruction.
+type MDFunc func(m *VM, stdout, stderr io.Writer) (exit int, err error)
+
+// mdRegistry maps MD subroutine names to their implementations.
+var mdRegistry = struct {
+	sync.RWMutex
+	funcs map[string]MDFunc
+}{funcs: make(map[string]MDFunc)}
+
+func init() {
+	RegisterMD("MDERCH", mdErch)
+	RegisterMD("MDOUT", mdOut)
+	RegisterMD("MDQUIT", mdQuit)
+	RegisterMD("MDREAD", mdRead)
+	RegisterMD("MDSELI", mdSelI)
+	RegisterMD("MDSELO", mdSelO)
+}
+
+// RegisterMD registers (or replaces) the implementation of an MD subroutine.
+// The assembler turns a GOSUB to a registered name into a host call, so
+// subroutines must be registered before the program is assembled.
+func RegisterMD(name string, fn MDFunc) {
+	mdRegistry.Lock()
+	defer mdRegistry.Unlock()
+	if fn == nil {
+		delete(mdRegistry.funcs, name)
+		return
+	}
+	mdRegistry.funcs[name] = fn
+}
+
+// LookupMD returns the implementation of an MD subroutine.
+func LookupMD(name string) (MDFunc, bool) {
+	mdRegistry.RLock()
+	defer mdRegistry.RUnlock()
+	fn, ok := mdRegistry.funcs[name]
+	return fn, ok
+}
+
+// mdErch copies register C to the message stream.
+func mdErch(m *VM, stdout, stderr io.Writer) (int, error) {
+	if m.C == '$' {
+		m.output(stderr, "\n", true)
+	} else {
+		m.output(stderr, string(byte(m.C)), true)
+	}
+	return 1, nil
+}
+
+// mdOut copies register C to the current output stream.
+// The end of input marker, EOF, is not copied.
+func mdOut(m *VM, stdout, stderr io.Writer) (int, error) {
+	if m.C != EOF {
+		m.output(stdout, string(byte(m.C)), false)
+	}
+	return 1, nil
+}
+
+// mdQuit stops the machine.
+func mdQuit(m *VM, stdout, stderr io.Writer) (int, error) {
+	// force the program counter back to this instruction
+	m.PC = m.PC - 1
+	// signal that we have stopped the machine
+	m.Registers.Halted = true
+	return 1, ErrQuit
+}
+
+// mdRead loads the next character from the current input stream into register C.
+// At the end of input, it loads EOF into register C and takes exit 2.
+func mdRead(m *VM, stdout, stderr io.Writer) (int, error) {
+	if m.C = m.readChar(); m.C == EOF {
+		return 2, nil
+	}
+	return 1, nil
+}
+
+// mdSelI selects the input stream whose number is in register A.
+// It takes exit 2 if there is no such stream.
+func mdSelI(m *VM, stdout, stderr io.Writer) (int, error) {
+	if !m.selectInput(m.A) {
+		return 2, nil
+	}
+	return 1, nil
+}
+
+// mdSelO selects the output stream whose number is in register A.
+// It takes exit 2 if there is no such stream.
+func mdSelO(m *VM, stdout, stderr io.Writer) (int, error) {
+	if !m.selectOutput(m.A) {
+		return 2, nil
+	}
+	return 1, nil
+}
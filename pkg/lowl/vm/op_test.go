@@ -57,11 +57,47 @@ func TestVM(t *testing.T) {
 			m.SetWord(input.V2.address, vm.Word{Op: op.CON, Value: input.V2.value})
 		}
 	}
+	// newstack gives the machine a stack region of Core[20:24], with FFPT
+	// in address 1, LFPT in address 2, and the pointers set to ffpt and lfpt.
+	newstack := func(ffpt, lfpt int) {
+		m.Registers.FFPT, m.Registers.LFPT, m.Registers.LCH, m.Registers.LNM = 1, 2, 1, 1
+		m.Registers.StackStart, m.Registers.StackEnd = 20, 24
+		m.SetWord(1, vm.Word{Value: ffpt})
+		m.SetWord(2, vm.Word{Value: lfpt})
+	}
+	testStack := func(ffpt, lfpt int) {
+		if got := m.Core[1].Value; got != ffpt {
+			t.Errorf("%s: ffpt: want %d: got %d\n", opc, ffpt, got)
+		}
+		if got := m.Core[2].Value; got != lfpt {
+			t.Errorf("%s: lfpt: want %d: got %d\n", opc, lfpt, got)
+		}
+	}
 	step := func(stdout, stdmsg io.Writer) {
 		if err := m.Step(stdout, stdmsg); err != nil {
 			t.Errorf("%s: want nil: got %v\n", opc, err)
 		}
 	}
+	// overlap moves the text at src to dst, with SRCPT in address 1 and
+	// DSTPT in address 2, and checks the text that is left at dst.
+	overlap := func(src, dst int, text, want string) {
+		input = input_t{A: len(text), V: val_t{address: 1, value: src}, V2: val_t{address: 2, value: dst}}
+		newvm()
+		m.SetWord(0, vm.Word{Op: opc, Value: input.V.address, ValueTwo: input.V2.address})
+		m.SetWord(input.V.address, vm.Word{Value: input.V.value})
+		m.SetWord(input.V2.address, vm.Word{Value: input.V2.value})
+		for n, ch := range text {
+			m.SetWord(src+n, vm.Word{Value: int(ch)})
+		}
+		step(nil, nil)
+		got := make([]byte, len(want))
+		for n := range got {
+			got[n] = byte(m.Core[dst+n].Value)
+		}
+		if string(got) != want {
+			t.Errorf("%s: %d to %d: want %q: got %q\n", opc, src, dst, want, string(got))
+		}
+	}
 	testA := func() {
 		if m.A != expect.A {
 			t.Errorf("%s: r.A: want %d: got %d\n", opc, expect.A, m.A)
@@ -152,14 +188,24 @@ func TestVM(t *testing.T) {
 	test(nil, nil)
 
 	opc = op.ALIGN
-	input = input_t{}
-	expect = expect_t{PC: 1}
-	newvm()
-	m.SetWord(0, vm.Word{Op: opc})
-	if err := m.Step(nil, nil); err == nil {
-		t.Errorf("%s: want invalid op: got nil\n", opc)
-	} else if !errors.Is(err, vm.ErrInvalidOp) {
-		t.Errorf("%s: want invalid op: got %v\n", opc, err)
+	for _, tc := range []struct {
+		lch, lnm int
+		a, want  int
+	}{
+		{1, 1, 13, 13},
+		{1, 2, 12, 12},
+		{1, 2, 13, 14},
+		{1, 4, 13, 16},
+		{1, 4, 16, 16},
+		{2, 2, 13, 13},
+		{4, 2, 13, 13},
+	} {
+		input = input_t{A: tc.a, B: 4, C: 5}
+		expect = expect_t{PC: 1, A: tc.want, B: input.B, C: input.C}
+		newvm()
+		m.Registers.LCH, m.Registers.LNM = tc.lch, tc.lnm
+		m.SetWord(0, vm.Word{Op: opc})
+		test(nil, nil)
 	}
 
 	opc = op.ANDL
@@ -234,8 +280,34 @@ func TestVM(t *testing.T) {
 		}
 	}
 
+	// moving backwards shifts a field up; shifting it down repeats words
+	overlap(16, 18, "abcde", "abcde")
+	overlap(18, 16, "abcde", "edede")
+
 	opc = op.BSTK
-	t.Errorf("%s: not tested\n", opc)
+	input = input_t{A: 7}
+	newvm()
+	newstack(20, 24)
+	m.SetWord(0, vm.Word{Op: opc})
+	step(nil, nil)
+	testStack(20, 23)
+	if got := m.Core[23].Value; got != 7 {
+		t.Errorf("%s: [23]: want 7: got %d\n", opc, got)
+	}
+	newvm()
+	newstack(20, 21)
+	m.SetWord(0, vm.Word{Op: opc})
+	if err := m.Step(nil, nil); !errors.Is(err, vm.ErrStackOverflow) {
+		t.Errorf("%s: want stack overflow: got %v\n", opc, err)
+	}
+	newvm()
+	newstack(20, 21)
+	m.Registers.ERLSO = 9
+	m.SetWord(0, vm.Word{Op: opc})
+	step(nil, nil)
+	if m.PC != 9 {
+		t.Errorf("%s: erlso: pc: want 9: got %d\n", opc, m.PC)
+	}
 
 	opc = op.BUMP
 	input = input_t{A: 3, B: 12, C: 49, V: val_t{1, 11}, V2: val_t{value: 2}}
@@ -244,6 +316,23 @@ func TestVM(t *testing.T) {
 	m.SetWord(0, vm.Word{Op: opc, Value: input.V.address, ValueTwo: input.V2.value})
 	test(nil, nil)
 
+	// signed and unsigned (A flag) comparisons near the sign boundary,
+	// shared by CAI and CAV
+	signBoundary := []struct {
+		bits, a, i, flag int
+		cmp              vm.CMPRSLT
+	}{
+		{16, 32767, -32768, 0, vm.IS_GR},
+		{16, 32767, -32768, vm.CompareAddresses, vm.IS_LT},
+		{16, -1, 0, 0, vm.IS_LT},
+		{16, -1, 0, vm.CompareAddresses, vm.IS_GR},
+		{16, -32768, -32768, vm.CompareAddresses, vm.IS_EQ},
+		{32, 2147483647, -2147483648, 0, vm.IS_GR},
+		{32, 2147483647, -2147483648, vm.CompareAddresses, vm.IS_LT},
+		{0, -1, 0, 0, vm.IS_LT},
+		{0, -1, 0, vm.CompareAddresses, vm.IS_GR},
+	}
+
 	opc = op.CAI
 	for _, tc := range []struct {
 		a, i int
@@ -259,6 +348,14 @@ func TestVM(t *testing.T) {
 		m.SetWord(0, vm.Word{Op: opc, Value: input.V.address})
 		test(nil, nil)
 	}
+	for _, tc := range signBoundary {
+		input = input_t{A: tc.a, B: 4, C: 5, V: val_t{1, 8}, V2: val_t{8, tc.i}}
+		expect = expect_t{PC: 1, A: input.A, B: input.B, C: input.C, V: input.V, V2: input.V2, Cmp: tc.cmp}
+		newvm()
+		_ = m.SetWordSize(tc.bits)
+		m.SetWord(0, vm.Word{Op: opc, Value: input.V.address, ValueTwo: tc.flag})
+		test(nil, nil)
+	}
 
 	opc = op.CAL
 	for _, tc := range []struct {
@@ -291,6 +388,14 @@ func TestVM(t *testing.T) {
 		m.SetWord(0, vm.Word{Op: opc, Value: input.V.address})
 		test(nil, nil)
 	}
+	for _, tc := range signBoundary {
+		input = input_t{A: tc.a, B: 4, C: 5, V: val_t{1, tc.i}}
+		expect = expect_t{PC: 1, A: input.A, B: input.B, C: input.C, V: input.V, Cmp: tc.cmp}
+		newvm()
+		_ = m.SetWordSize(tc.bits)
+		m.SetWord(0, vm.Word{Op: opc, Value: input.V.address, ValueTwo: tc.flag})
+		test(nil, nil)
+	}
 
 	opc = op.CCI
 	for _, tc := range []struct {
@@ -341,7 +446,28 @@ func TestVM(t *testing.T) {
 	}
 
 	opc = op.CFSTK
-	t.Errorf("%s: not tested\n", opc)
+	input = input_t{A: 3, C: 'x'}
+	newvm()
+	newstack(20, 24)
+	m.SetWord(0, vm.Word{Op: opc})
+	step(nil, nil)
+	testStack(21, 24)
+	if got := m.Core[20].Value; got != 'x' {
+		t.Errorf("%s: [20]: want %d: got %d\n", opc, 'x', got)
+	}
+	// FFPT moves by the length of a character, not of a number
+	newvm()
+	newstack(20, 24)
+	m.Registers.LCH, m.Registers.LNM = 1, 2
+	m.SetWord(0, vm.Word{Op: opc})
+	step(nil, nil)
+	testStack(21, 24)
+	newvm()
+	newstack(23, 24)
+	m.SetWord(0, vm.Word{Op: opc})
+	if err := m.Step(nil, nil); !errors.Is(err, vm.ErrStackOverflow) {
+		t.Errorf("%s: want stack overflow: got %v\n", opc, err)
+	}
 
 	opc = op.CLEAR
 	input = input_t{A: 3, B: 12, C: 49, V: val_t{1, 11}}
@@ -368,7 +494,7 @@ func TestVM(t *testing.T) {
 	m.SetWord(0, vm.Word{Op: opc})
 	if err := m.Step(nil, nil); err == nil {
 		t.Errorf("%s: want underflow: got nil\n", opc)
-	} else if !errors.Is(err, vm.ErrStackUnderflow) {
+	} else if !errors.Is(err, vm.ErrReturnStackUnderflow) {
 		t.Errorf("%s: want underflow: got %v\n", opc, err)
 	}
 	input = input_t{RS: []int{99}}
@@ -400,7 +526,23 @@ func TestVM(t *testing.T) {
 	}
 
 	opc = op.EXIT
-	t.Errorf("%s: not tested\n", opc)
+	input = input_t{RS: []int{3, 5}}
+	expect = expect_t{PC: 5, RS: []int{3}}
+	newvm()
+	m.SetWord(0, vm.Word{Op: opc, Value: 2})
+	test(nil, nil)
+	if m.Registers.JumpValue != 2 {
+		t.Errorf("%s: jump: want %d: got %d\n", opc, 2, m.Registers.JumpValue)
+	}
+	input = input_t{}
+	expect = expect_t{PC: 1}
+	newvm()
+	m.SetWord(0, vm.Word{Op: opc, Value: 2})
+	if err := m.Step(nil, nil); err == nil {
+		t.Errorf("%s: want underflow: got nil\n", opc)
+	} else if !errors.Is(err, vm.ErrReturnStackUnderflow) {
+		t.Errorf("%s: want underflow: got %v\n", opc, err)
+	}
 
 	opc = op.FMOVE
 	input = input_t{}
@@ -460,8 +602,34 @@ func TestVM(t *testing.T) {
 		}
 	}
 
+	// moving forwards shifts a field down; shifting it up repeats words
+	overlap(18, 16, "abcde", "abcde")
+	overlap(16, 18, "abcde", "ababa")
+
 	opc = op.FSTK
-	t.Errorf("%s: not tested\n", opc)
+	input = input_t{A: 7}
+	newvm()
+	newstack(20, 24)
+	m.SetWord(0, vm.Word{Op: opc})
+	step(nil, nil)
+	testStack(21, 24)
+	if got := m.Core[20].Value; got != 7 {
+		t.Errorf("%s: [20]: want 7: got %d\n", opc, got)
+	}
+	newvm()
+	newstack(23, 24)
+	m.SetWord(0, vm.Word{Op: opc})
+	if err := m.Step(nil, nil); !errors.Is(err, vm.ErrStackOverflow) {
+		t.Errorf("%s: want stack overflow: got %v\n", opc, err)
+	}
+	newvm()
+	newstack(23, 24)
+	m.Registers.ERLSO = 9
+	m.SetWord(0, vm.Word{Op: opc})
+	step(nil, nil)
+	if m.PC != 9 {
+		t.Errorf("%s: erlso: pc: want 9: got %d\n", opc, m.PC)
+	}
 
 	opc = op.GO
 	input = input_t{Cmp: vm.IS_LT}
@@ -481,12 +649,23 @@ func TestVM(t *testing.T) {
 	test(nil, nil)
 
 	opc = op.GOADD
-	t.Errorf("%s: not tested\n", opc)
-	//input = input_t{}
-	//expect = expect_t{PC: 8}
-	//newvm()
-	//m.SetWord(0, vm.Word{Op: opc, Value: 8})
-	//test(nil, nil)
+	input = input_t{V: val_t{address: 8, value: 3}}
+	expect = expect_t{PC: 1, V: input.V}
+	newvm()
+	m.SetWord(0, vm.Word{Op: opc, Value: input.V.address})
+	test(nil, nil)
+	if m.Registers.JumpValue != input.V.value {
+		t.Errorf("%s: jump: want %d: got %d\n", opc, input.V.value, m.Registers.JumpValue)
+	}
+	input = input_t{}
+	expect = expect_t{PC: 1}
+	newvm()
+	m.SetWord(0, vm.Word{Op: opc, Value: vm.MAX_WORDS})
+	if err := m.Step(nil, nil); err == nil {
+		t.Errorf("%s: want out of range: got nil\n", opc)
+	} else if !errors.Is(err, vm.ErrAddressOutOfRange) {
+		t.Errorf("%s: want out of range: got %v\n", opc, err)
+	}
 
 	opc = op.GOEQ
 	input = input_t{Cmp: vm.IS_LT}
@@ -608,9 +787,40 @@ func TestVM(t *testing.T) {
 	test(nil, nil)
 
 	opc = op.GOSUB
-	t.Errorf("%s: not tested\n", opc)
+	input = input_t{A: 7, RS: []int{3}}
+	expect = expect_t{PC: 8, A: input.A, RS: []int{3, 1}}
+	newvm()
+	m.SetWord(0, vm.Word{Op: opc, Value: 8})
+	var calls []int
+	m.Hooks = &vm.Hooks{OnCall: func(m *vm.VM, pc, target, param int) {
+		calls = append(calls, pc, target, param)
+	}}
+	test(nil, nil)
+	if len(calls) != 3 || calls[0] != 0 || calls[1] != 8 || calls[2] != input.A {
+		t.Errorf("%s: call: want [0 8 %d]: got %v\n", opc, input.A, calls)
+	}
+
+	// GOTBL takes the exit number set by a machine-dependent subroutine
+	// just as it takes the one set by EXIT.
 	opc = op.GOTBL
-	t.Errorf("%s: not tested\n", opc)
+	vm.RegisterMD("MDTEST", func(m *vm.VM, stdout, stderr io.Writer) (int, error) {
+		return 2, nil
+	})
+	input = input_t{}
+	expect = expect_t{PC: 8}
+	newvm()
+	m.SetWord(0, vm.Word{Op: op.MDCALL, Text: "MDTEST"})
+	m.SetWord(1, vm.Word{Op: opc, Value: 5, ValueTwo: 1})
+	m.SetWord(2, vm.Word{Op: opc, Value: 8, ValueTwo: 2})
+	for n := 0; n < 3; n++ {
+		if err := m.Step(nil, nil); err != nil {
+			t.Fatalf("%s: want nil: got %v\n", opc, err)
+		}
+	}
+	if m.PC != expect.PC {
+		t.Errorf("%s: pc: want %d: got %d\n", opc, expect.PC, m.PC)
+	}
+	vm.RegisterMD("MDTEST", nil)
 
 	opc = op.HALT
 	input = input_t{A: 3, B: 4, C: 5}
@@ -656,6 +866,16 @@ func TestVM(t *testing.T) {
 	m.SetWord(0, vm.Word{Op: opc, Value: input.V.address})
 	test(nil, nil)
 
+	input = input_t{V: val_t{1, -1}}
+	expect = expect_t{PC: 1}
+	newvm()
+	m.SetWord(0, vm.Word{Op: opc, Value: input.V.address})
+	if err := m.Step(nil, nil); err == nil {
+		t.Errorf("%s: want out of range: got nil\n", opc)
+	} else if !errors.Is(err, vm.ErrAddressOutOfRange) {
+		t.Errorf("%s: want out of range: got %v\n", opc, err)
+	}
+
 	opc = op.LAL
 	input = input_t{A: 3, B: 4, C: 5, V: val_t{0, 88}}
 	expect = expect_t{PC: 1, A: input.V.value, B: input.B, C: input.C, V: input.V}
@@ -679,6 +899,16 @@ func TestVM(t *testing.T) {
 	m.SetWord(0, vm.Word{Op: opc, Value: input.V.address})
 	test(nil, nil)
 
+	input = input_t{}
+	expect = expect_t{PC: 1}
+	newvm()
+	m.SetWord(0, vm.Word{Op: opc, Value: vm.MAX_WORDS})
+	if err := m.Step(nil, nil); err == nil {
+		t.Errorf("%s: want out of range: got nil\n", opc)
+	} else if !errors.Is(err, vm.ErrAddressOutOfRange) {
+		t.Errorf("%s: want out of range: got %v\n", opc, err)
+	}
+
 	opc = op.LBV
 	input = input_t{A: 3, B: 4, C: 5, V: val_t{1, 88}}
 	expect = expect_t{PC: 1, A: input.A, B: input.V.value, C: input.C}
@@ -709,13 +939,44 @@ func TestVM(t *testing.T) {
 	m.SetWord(0, vm.Word{Op: opc, Value: input.V.value})
 	test(nil, nil)
 
+	opc = op.MDCALL
+	vm.RegisterMD("MDTEST", func(m *vm.VM, stdout, stderr io.Writer) (int, error) {
+		m.A = m.A + m.B
+		return 2, nil
+	})
+	input = input_t{A: 3, B: 4, C: 5}
+	expect = expect_t{PC: 1, A: 7, B: input.B, C: input.C}
+	newvm()
+	m.SetWord(0, vm.Word{Op: opc, Text: "MDTEST"})
+	test(nil, nil)
+	if m.Registers.JumpValue != 2 {
+		t.Errorf("%s: jump: want %d: got %d\n", opc, 2, m.Registers.JumpValue)
+	}
+	input = input_t{}
+	expect = expect_t{PC: 1}
+	newvm()
+	m.SetWord(0, vm.Word{Op: opc, Text: "MDNONE"})
+	if err := m.Step(nil, nil); err == nil {
+		t.Errorf("%s: want not implemented: got nil\n", opc)
+	} else if !errors.Is(err, vm.ErrNotImplemented) {
+		t.Errorf("%s: want not implemented: got %v\n", opc, err)
+	} else {
+		var re *vm.RuntimeError
+		if !errors.As(err, &re) {
+			t.Errorf("%s: want runtime error: got %T\n", opc, err)
+		} else if re.PC != 0 || re.Word.Op != opc || re.Word.Text != "MDNONE" {
+			t.Errorf("%s: want 0 %s %s: got %d %s %s\n", opc, opc, "MDNONE", re.PC, re.Word.Op, re.Word.Text)
+		}
+	}
+	vm.RegisterMD("MDTEST", nil)
+
 	opc = op.MDERCH
 	input = input_t{A: 3, B: 4, C: 'A'}
 	expect = expect_t{PC: 1, A: input.A, B: input.B, C: input.C, Text: "A"}
 	out = &bytes.Buffer{}
 	newvm()
 	m.SetWord(0, vm.Word{Op: opc})
-	test(out, nil)
+	test(nil, out)
 	if b := out.Bytes(); len(b) != len(expect.Text) {
 		t.Errorf("%s: out.len: want %d: got %d\n", opc, len(expect.Text), len(b))
 	} else if s := string(b); s != expect.Text {
@@ -759,7 +1020,7 @@ func TestVM(t *testing.T) {
 	out = &bytes.Buffer{}
 	newvm()
 	m.SetWord(0, vm.Word{Op: opc, Text: input.Text})
-	test(out, nil)
+	test(nil, out)
 	if b := out.Bytes(); len(b) != len(expect.Text) {
 		t.Errorf("%s: out.len: want %d: got %d\n", opc, len(expect.Text), len(b))
 	} else if s := string(b); s != expect.Text {
@@ -890,5 +1151,21 @@ func TestVM(t *testing.T) {
 	}
 
 	opc = op.UNSTK
-	t.Errorf("%s: not tested\n", opc)
+	input = input_t{}
+	newvm()
+	newstack(20, 23)
+	m.SetWord(23, vm.Word{Value: 7})
+	m.SetWord(0, vm.Word{Op: opc, Value: 3})
+	step(nil, nil)
+	testStack(20, 24)
+	if got := m.Core[3].Value; got != 7 {
+		t.Errorf("%s: *v: want 7: got %d\n", opc, got)
+	}
+	newvm()
+	newstack(20, 24)
+	m.SetWord(0, vm.Word{Op: opc, Value: 3})
+	if err := m.Step(nil, nil); !errors.Is(err, vm.ErrStackUnderflow) {
+		t.Errorf("%s: want stack underflow: got %v\n", opc, err)
+	}
+	testStack(20, 24)
 }
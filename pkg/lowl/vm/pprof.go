@@ -0,0 +1,146 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package vm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"sort"
+)
+
+// WritePprof writes the profile in the gzipped protocol buffer format
+// read by "go tool pprof". Each LOWL subroutine is a function, each
+// address is a location, and the sample value is the number of
+// instructions executed.
+func (p *Profile) WritePprof(w io.Writer) error {
+	strings := map[string]int{"": 0}
+	table := []string{""}
+	str := func(s string) int {
+		if n, ok := strings[s]; ok {
+			return n
+		}
+		strings[s] = len(table)
+		table = append(table, s)
+		return strings[s]
+	}
+
+	b := &protobuf{}
+	valueType := func(field int, kind, unit string) {
+		vt := &protobuf{}
+		vt.int(1, str(kind))
+		vt.int(2, str(unit))
+		b.message(field, vt)
+	}
+	valueType(1, "instructions", "count")
+
+	// sort the samples so that the output is repeatable
+	var keys []string
+	for key := range p.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	functions := map[string]int{}
+	locations := map[int]int{}
+	var functionOrder []string
+	var locationOrder []int
+	locationFunc := map[int]string{}
+	for _, key := range keys {
+		s := p.samples[key]
+		sb, ids := &protobuf{}, make([]int, len(s.pcs))
+		for n, pc := range s.pcs {
+			if _, ok := functions[s.funcs[n]]; !ok {
+				functions[s.funcs[n]] = len(functionOrder) + 1
+				functionOrder = append(functionOrder, s.funcs[n])
+			}
+			if _, ok := locations[pc]; !ok {
+				locations[pc] = len(locationOrder) + 1
+				locationOrder = append(locationOrder, pc)
+				locationFunc[pc] = s.funcs[n]
+			}
+			ids[n] = locations[pc]
+		}
+		sb.packed(1, ids)
+		sb.packed(2, []int{s.count})
+		b.message(2, sb)
+	}
+
+	for n, pc := range locationOrder {
+		lb, line := &protobuf{}, &protobuf{}
+		lb.int(1, n+1)
+		lb.int(3, pc)
+		line.int(1, functions[locationFunc[pc]])
+		line.int(2, p.lines[pc])
+		lb.message(4, line)
+		b.message(4, lb)
+	}
+
+	for n, name := range functionOrder {
+		fb := &protobuf{}
+		fb.int(1, n+1)
+		fb.int(2, str(name))
+		fb.int(3, str(name))
+		fb.int(4, str(p.SourceFile))
+		b.message(5, fb)
+	}
+
+	valueType(11, "instructions", "count")
+	b.int(12, 1)
+	for _, s := range table {
+		b.bytes(6, []byte(s))
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(b.Bytes()); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// protobuf is a minimal encoder for the protocol buffer wire format.
+// Zero values are omitted, as proto3 requires.
+type protobuf struct {
+	bytes.Buffer
+}
+
+func (b *protobuf) varint(x uint64) {
+	for x >= 0x80 {
+		b.WriteByte(byte(x) | 0x80)
+		x >>= 7
+	}
+	b.WriteByte(byte(x))
+}
+
+// int writes a varint field.
+func (b *protobuf) int(field, x int) {
+	if x == 0 {
+		return
+	}
+	b.varint(uint64(field)<<3 | 0)
+	b.varint(uint64(x))
+}
+
+// bytes writes a length-delimited field. Strings are always written,
+// because the string table must start with the empty string.
+func (b *protobuf) bytes(field int, data []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	b.Write(data)
+}
+
+// message writes an embedded message.
+func (b *protobuf) message(field int, m *protobuf) {
+	b.bytes(field, m.Bytes())
+}
+
+// packed writes a packed repeated varint field.
+func (b *protobuf) packed(field int, xs []int) {
+	pb := &protobuf{}
+	for _, x := range xs {
+		pb.varint(uint64(x))
+	}
+	b.bytes(field, pb.Bytes())
+}
@@ -0,0 +1,234 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package vm
+
+import (
+	"fmt"
+	"github.com/maloquacious/ml_i/pkg/lowl/op"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Profile counts the instructions executed by the machine.
+// Counts are kept per PC, per opcode and per subroutine. Subroutines are
+// found from the return stack: each GOSUB that pushes a return address
+// enters the subroutine at its target, and the matching EXIT leaves it.
+type Profile struct {
+	SourceFile  string // name of the source file, used in the pprof output
+	Steps       int
+	PCs         map[int]int
+	Ops         map[op.Code]int
+	Subroutines map[string]*SubroutineProfile
+
+	frames  []string       // subroutine names, outermost first
+	names   map[int]string // cache of subroutine names by entry address
+	lines   map[int]int    // source line by PC
+	samples map[string]*sample
+}
+
+// SubroutineProfile is the profile of a single subroutine.
+// Exclusive counts the instructions executed by the subroutine itself and
+// Inclusive adds the instructions executed by the subroutines it calls.
+type SubroutineProfile struct {
+	Calls     int
+	Exclusive int
+	Inclusive int
+}
+
+// sample is the count for one call stack.
+type sample struct {
+	pcs   []int    // innermost first
+	funcs []string // function for each pc
+	count int
+}
+
+// NewProfile returns an empty profile.
+func NewProfile() *Profile {
+	return &Profile{
+		PCs:         make(map[int]int),
+		Ops:         make(map[op.Code]int),
+		Subroutines: make(map[string]*SubroutineProfile),
+		names:       make(map[int]string),
+		lines:       make(map[int]int),
+		samples:     make(map[string]*sample),
+	}
+}
+
+// SetProfile collects a profile of every step into p. A nil p stops profiling.
+func (m *VM) SetProfile(p *Profile) {
+	m.profile = p
+}
+
+// subroutine returns the name of the subroutine at address.
+func (p *Profile) subroutine(m *VM, address int) string {
+	if name, ok := p.names[address]; ok {
+		return name
+	}
+	name := fmt.Sprintf("@%d", address)
+	if 0 <= address && address < len(m.Core) && m.Core[address].Source.Op == op.SUBR && m.Core[address].Text != "" {
+		name = m.Core[address].Text
+	} else {
+		var labels []string
+		for label, value := range m.Symbols {
+			if value == address {
+				labels = append(labels, label)
+			}
+		}
+		if len(labels) != 0 {
+			sort.Strings(labels)
+			name = labels[0]
+		}
+	}
+	p.names[address] = name
+	return name
+}
+
+// sync matches the subroutine names to the return stack.
+func (p *Profile) sync(m *VM) {
+	if len(p.frames) == 0 {
+		name := m.Name
+		if name == "" {
+			name = "main"
+		}
+		p.frames = append(p.frames, name)
+		p.enter(name)
+	}
+	for len(p.frames) > len(m.RS)+1 {
+		p.frames = p.frames[:len(p.frames)-1]
+	}
+	for len(p.frames) < len(m.RS)+1 {
+		name := p.subroutine(m, m.PC)
+		p.frames = append(p.frames, name)
+		p.enter(name)
+	}
+}
+
+// enter counts a call to the subroutine.
+func (p *Profile) enter(name string) {
+	s, ok := p.Subroutines[name]
+	if !ok {
+		s = &SubroutineProfile{}
+		p.Subroutines[name] = s
+	}
+	s.Calls++
+}
+
+// before counts the instruction at PC against the current call stack.
+func (p *Profile) before(m *VM) {
+	p.sync(m)
+	pc := m.PC
+	p.Steps++
+	p.PCs[pc]++
+	if 0 <= pc && pc < len(m.Core) {
+		p.Ops[m.Core[pc].Op]++
+		p.lines[pc] = m.Core[pc].Source.Line
+	}
+
+	top := len(p.frames) - 1
+	p.Subroutines[p.frames[top]].Exclusive++
+	for n, name := range p.frames {
+		seen := false
+		for _, outer := range p.frames[:n] {
+			if seen = outer == name; seen {
+				break
+			}
+		}
+		if !seen {
+			p.Subroutines[name].Inclusive++
+		}
+	}
+
+	key := &strings.Builder{}
+	_, _ = fmt.Fprintf(key, "%d", pc)
+	for n := len(m.RS) - 1; n >= 0; n-- {
+		_, _ = fmt.Fprintf(key, ",%d", m.RS[n]-1)
+	}
+	s, ok := p.samples[key.String()]
+	if !ok {
+		s = &sample{pcs: []int{pc}, funcs: []string{p.frames[top]}}
+		for n := len(m.RS) - 1; n >= 0; n-- {
+			call := m.RS[n] - 1
+			s.pcs, s.funcs = append(s.pcs, call), append(s.funcs, p.frames[n])
+			if 0 <= call && call < len(m.Core) {
+				p.lines[call] = m.Core[call].Source.Line
+			}
+		}
+		p.samples[key.String()] = s
+	}
+	s.count++
+}
+
+// after updates the call stack once the instruction has executed.
+func (p *Profile) after(m *VM) {
+	p.sync(m)
+}
+
+// WriteText writes a report of the profile.
+// Only the busiest limit addresses are listed; a limit of 0 lists them all.
+func (p *Profile) WriteText(w io.Writer, limit int) error {
+	percent := func(n int) float64 {
+		if p.Steps == 0 {
+			return 0
+		}
+		return 100 * float64(n) / float64(p.Steps)
+	}
+
+	if _, err := fmt.Fprintf(w, "steps %d\n\nsubroutines:\n  %-16s %8s %10s %7s %10s %7s\n", p.Steps, "name", "calls", "exclusive", "%", "inclusive", "%"); err != nil {
+		return err
+	}
+	var names []string
+	for name := range p.Subroutines {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := p.Subroutines[names[i]], p.Subroutines[names[j]]
+		if a.Exclusive != b.Exclusive {
+			return a.Exclusive > b.Exclusive
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		s := p.Subroutines[name]
+		_, _ = fmt.Fprintf(w, "  %-16s %8d %10d %6.2f%% %10d %6.2f%%\n", name, s.Calls, s.Exclusive, percent(s.Exclusive), s.Inclusive, percent(s.Inclusive))
+	}
+
+	_, _ = fmt.Fprintf(w, "\nopcodes:\n  %-8s %10s %7s\n", "op", "count", "%")
+	var ops []op.Code
+	for code := range p.Ops {
+		ops = append(ops, code)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if p.Ops[ops[i]] != p.Ops[ops[j]] {
+			return p.Ops[ops[i]] > p.Ops[ops[j]]
+		}
+		return ops[i] < ops[j]
+	})
+	for _, code := range ops {
+		_, _ = fmt.Fprintf(w, "  %-8s %10d %6.2f%%\n", code, p.Ops[code], percent(p.Ops[code]))
+	}
+
+	_, _ = fmt.Fprintf(w, "\naddresses:\n  %5s %5s %10s %7s\n", "pc", "line", "count", "%")
+	var pcs []int
+	for pc := range p.PCs {
+		pcs = append(pcs, pc)
+	}
+	sort.Slice(pcs, func(i, j int) bool {
+		if p.PCs[pcs[i]] != p.PCs[pcs[j]] {
+			return p.PCs[pcs[i]] > p.PCs[pcs[j]]
+		}
+		return pcs[i] < pcs[j]
+	})
+	if limit > 0 && len(pcs) > limit {
+		pcs = pcs[:limit]
+	}
+	for _, pc := range pcs {
+		_, err := fmt.Fprintf(w, "  %5d %5d %10d %6.2f%%\n", pc, p.lines[pc], p.PCs[pc], percent(p.PCs[pc]))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package vm_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/maloquacious/ml_i/pkg/lowl/op"
+	"github.com/maloquacious/ml_i/pkg/lowl/vm"
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestProfile tests the counts and the text and pprof output of the profiler.
+func TestProfile(t *testing.T) {
+	m := &vm.VM{Name: "MAIN"}
+	m.SetWord(0, vm.Word{Op: op.GOSUB, Value: 3})
+	m.SetWord(1, vm.Word{Op: op.GOSUB, Value: 3})
+	m.SetWord(2, vm.Word{Op: op.MDCALL, Text: "MDQUIT"})
+	m.SetWord(3, vm.Word{Op: op.NOOP, Text: "SUB"})
+	m.SetWord(4, vm.Word{Op: op.AAL, Value: 1})
+	m.SetWord(5, vm.Word{Op: op.EXIT, Value: 1})
+	m.Core[3].Source.Op = op.SUBR
+
+	p := vm.NewProfile()
+	m.SetProfile(p)
+	if err := m.Run(nil, nil); err != nil {
+		t.Fatalf("run: want nil: got %v\n", err)
+	}
+	if p.Steps != 9 {
+		t.Errorf("steps: want 9: got %d\n", p.Steps)
+	}
+	if p.PCs[4] != 2 || p.Ops[op.GOSUB] != 2 {
+		t.Errorf("counts: want pc 4 twice, GOSUB twice: got %d %d\n", p.PCs[4], p.Ops[op.GOSUB])
+	}
+	for name, want := range map[string]vm.SubroutineProfile{
+		"MAIN": {Calls: 1, Exclusive: 3, Inclusive: 9},
+		"SUB":  {Calls: 2, Exclusive: 6, Inclusive: 6},
+	} {
+		if got := p.Subroutines[name]; got == nil || *got != want {
+			t.Errorf("%s: want %+v: got %+v\n", name, want, got)
+		}
+	}
+
+	b := &bytes.Buffer{}
+	if err := p.WriteText(b, 0); err != nil {
+		t.Fatalf("text: want nil: got %v\n", err)
+	} else if !strings.Contains(b.String(), "steps 9") || !strings.Contains(b.String(), "SUB") {
+		t.Errorf("text: want steps and SUB: got\n%s\n", b.String())
+	}
+
+	b.Reset()
+	if err := p.WritePprof(b); err != nil {
+		t.Fatalf("pprof: want nil: got %v\n", err)
+	}
+	zr, err := gzip.NewReader(b)
+	if err != nil {
+		t.Fatalf("pprof: want gzip: got %v\n", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("pprof: want gzip: got %v\n", err)
+	}
+	for _, want := range []string{"instructions", "MAIN", "SUB"} {
+		if !bytes.Contains(data, []byte(want)) {
+			t.Errorf("pprof: want %q in string table\n", want)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"github.com/maloquacious/ml_i/pkg/lowl/op"
+	"io"
+)
+
+// RecordingVersion is the version of the format written by Recording.WriteTo.
+const RecordingVersion = 1
+
+// recordingMagic starts every recording file.
+const recordingMagic = "LOWLRCRD"
+
+// Recording records a run so that it can be replayed and stepped backwards.
+//
+// To replay the run, it keeps a snapshot of the machine taken before the
+// first recorded step and every character the program read. To step
+// backwards, it keeps an undo record for each step with the registers
+// and the words of memory that the step changed. Output that has been
+// written is not taken back when stepping backwards.
+type Recording struct {
+	// Limit is the number of steps that can be undone.
+	// Zero means no limit. It does not limit what can be replayed.
+	Limit int
+	// Start is the snapshot taken before the first recorded step.
+	Start []byte
+	// Reads are the characters read by the program, in order.
+	Reads []Read
+
+	undo    []undoRecord
+	pending []Read // reads undone by StepBack, to be read again, last first
+	open    bool   // true while a step is running
+}
+
+// Read is a character read by the program. Char is EOF at the end of input.
+type Read struct {
+	Stream int
+	Char   int
+}
+
+// undoRecord has the state needed to undo one step.
+type undoRecord struct {
+	pc, a, b, c   int
+	cmp           CMPRSLT
+	jumpValue     int
+	halted        bool
+	input, output int
+	rs            []int // nil if the step could not change the return stack
+	words         []undoWord
+	reads         []Read
+}
+
+// undoWord is a word of memory as it was before the step changed it.
+type undoWord struct {
+	address int
+	word    Word
+}
+
+// NewRecording returns an empty recording.
+func NewRecording() *Recording {
+	return &Recording{}
+}
+
+// SetRecording records every step in r. A nil r stops recording.
+func (m *VM) SetRecording(r *Recording) {
+	m.recording = r
+}
+
+// Steps returns the number of steps that can be undone.
+func (r *Recording) Steps() int {
+	return len(r.undo)
+}
+
+// before starts the undo record for the instruction at PC.
+func (r *Recording) before(m *VM) {
+	if r.Start == nil {
+		b := &bytes.Buffer{}
+		if err := m.Snapshot(b); err == nil {
+			r.Start = b.Bytes()
+		}
+	}
+	if r.Limit > 0 && len(r.undo) >= r.Limit {
+		r.undo = r.undo[1:]
+	}
+	u := undoRecord{
+		pc: m.PC, a: m.A, b: m.B, c: m.C,
+		cmp:       m.Registers.Cmp,
+		jumpValue: m.Registers.JumpValue,
+		halted:    m.Registers.Halted,
+		input:     m.Streams.Input,
+		output:    m.Streams.Output,
+	}
+	if 0 <= m.PC && m.PC < len(m.Core) {
+		switch m.Core[m.PC].Op {
+		case op.CSS, op.EXIT, op.GOSUB, op.MDCALL:
+			u.rs = append([]int{}, m.RS...)
+		}
+	}
+	r.undo, r.open = append(r.undo, u), true
+}
+
+// after closes the undo record for the step.
+func (r *Recording) after() {
+	r.open = false
+}
+
+// saveWord adds the word at address to the current undo record.
+// It must be called before the word is changed.
+func (m *VM) saveWord(address int) {
+	if r := m.recording; r != nil && r.open {
+		u := &r.undo[len(r.undo)-1]
+		u.words = append(u.words, undoWord{address: address, word: m.Core[address]})
+	}
+}
+
+// replayChar returns a character undone by StepBack, if there is one.
+func (r *Recording) replayChar() (Read, bool) {
+	if len(r.pending) == 0 {
+		return Read{}, false
+	}
+	read := r.pending[len(r.pending)-1]
+	r.pending = r.pending[:len(r.pending)-1]
+	return read, true
+}
+
+// noteRead adds a character read by the program to the recording.
+// Characters read again after StepBack are already in Reads.
+func (r *Recording) noteRead(read Read, again bool) {
+	if !again {
+		r.Reads = append(r.Reads, read)
+	}
+	if r.open {
+		u := &r.undo[len(r.undo)-1]
+		u.reads = append(u.reads, read)
+	}
+}
+
+// StepBack undoes the last recorded step.
+// It returns ErrNoHistory if there is no step to undo.
+func (m *VM) StepBack() error {
+	r := m.recording
+	if r == nil || len(r.undo) == 0 {
+		return ErrNoHistory
+	}
+	u := r.undo[len(r.undo)-1]
+	r.undo = r.undo[:len(r.undo)-1]
+
+	for n := len(u.words) - 1; n >= 0; n-- {
+		m.Core[u.words[n].address] = u.words[n].word
+	}
+	for n := len(u.reads) - 1; n >= 0; n-- {
+		read := u.reads[n]
+		if read.Char != EOF && 0 <= read.Stream && read.Stream < len(m.Streams.Inputs) {
+			m.Streams.Inputs[read.Stream].Offset--
+		}
+		r.pending = append(r.pending, read)
+	}
+	if u.rs != nil {
+		m.RS = append(m.RS[:0], u.rs...)
+	}
+	m.PC, m.A, m.B, m.C = u.pc, u.a, u.b, u.c
+	m.Registers.Cmp, m.Registers.JumpValue, m.Registers.Halted = u.cmp, u.jumpValue, u.halted
+	m.selectInput(u.input)
+	m.selectOutput(u.output)
+	return nil
+}
+
+// WriteTo writes the information needed to replay the recording.
+// The undo records are not written.
+func (r *Recording) WriteTo(w io.Writer) (int64, error) {
+	b := &bytes.Buffer{}
+	b.WriteString(recordingMagic)
+	enc := gob.NewEncoder(b)
+	if err := enc.Encode(snapshotHeader{Version: RecordingVersion}); err != nil {
+		return 0, err
+	} else if err = enc.Encode(r.Start); err != nil {
+		return 0, err
+	} else if err = enc.Encode(r.Reads); err != nil {
+		return 0, err
+	}
+	return b.WriteTo(w)
+}
+
+// ReadRecording reads a recording written by WriteTo.
+func ReadRecording(rd io.Reader) (*Recording, error) {
+	br := bufio.NewReader(rd)
+	magic := make([]byte, len(recordingMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != recordingMagic {
+		return nil, ErrBadRecording
+	}
+	dec := gob.NewDecoder(br)
+	var header snapshotHeader
+	r := &Recording{}
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("header: %v: %w", err, ErrBadRecording)
+	} else if header.Version != RecordingVersion {
+		return nil, fmt.Errorf("version %d: want %d: %w", header.Version, RecordingVersion, ErrBadRecording)
+	} else if err = dec.Decode(&r.Start); err != nil {
+		return nil, fmt.Errorf("start: %v: %w", err, ErrBadRecording)
+	} else if err = dec.Decode(&r.Reads); err != nil {
+		return nil, fmt.Errorf("reads: %v: %w", err, ErrBadRecording)
+	}
+	return r, nil
+}
+
+// Replay returns a machine in the state where the recording started,
+// with input streams that return the characters that were read. Running
+// it repeats the recorded run. The caller adds the output streams.
+func (r *Recording) Replay() (*VM, error) {
+	if r.Start == nil {
+		return nil, fmt.Errorf("no start: %w", ErrBadRecording)
+	}
+	m, err := Restore(bytes.NewReader(r.Start))
+	if err != nil {
+		return nil, err
+	}
+	data := make([][]byte, len(m.Streams.Inputs))
+	for _, read := range r.Reads {
+		if read.Char == EOF {
+			continue
+		} else if read.Stream < 0 || read.Stream >= len(data) {
+			return nil, fmt.Errorf("stream %d: %w", read.Stream, ErrBadRecording)
+		}
+		data[read.Stream] = append(data[read.Stream], byte(read.Char))
+	}
+	input := m.Streams.Input
+	for n, in := range m.Streams.Inputs {
+		m.AddInputAt(in.Name, bytes.NewReader(data[n]), in.Offset)
+	}
+	m.selectInput(input)
+	return m, nil
+}
@@ -0,0 +1,99 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package vm_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/maloquacious/ml_i/pkg/lowl/op"
+	"github.com/maloquacious/ml_i/pkg/lowl/vm"
+	"strings"
+	"testing"
+)
+
+// TestRecording tests stepping backwards and replaying a recorded run.
+func TestRecording(t *testing.T) {
+	// read characters into X until the end of input, calling a subroutine for each
+	newvm := func() *vm.VM {
+		m := &vm.VM{}
+		m.SetWord(0, vm.Word{Op: op.MDCALL, Text: "MDREAD"}) // LOOP
+		m.SetWord(1, vm.Word{Op: op.GOTBL, Value: 7, ValueTwo: 2})
+		m.SetWord(2, vm.Word{Op: op.GOSUB, Value: 4})
+		m.SetWord(3, vm.Word{Op: op.GO, Value: 0})
+		m.SetWord(4, vm.Word{Op: op.LAV, Value: 9}) // SUB
+		m.SetWord(5, vm.Word{Op: op.STV, Value: 10})
+		m.SetWord(6, vm.Word{Op: op.EXIT, Value: 1})
+		m.SetWord(7, vm.Word{Op: op.MDCALL, Text: "MDQUIT"})
+		m.Core[9].Value = 42 // X
+		return m
+	}
+
+	m := newvm()
+	m.SetInput(strings.NewReader("ab"))
+	r := vm.NewRecording()
+	m.SetRecording(r)
+	if err := m.StepBack(); !errors.Is(err, vm.ErrNoHistory) {
+		t.Errorf("step back: want no history: got %v\n", err)
+	}
+	if err := m.Run(nil, nil); err != nil {
+		t.Fatalf("run: want nil: got %v\n", err)
+	}
+	steps, pc, c := r.Steps(), m.PC, m.C
+	if m.Core[10].Value != 42 || !m.Registers.Halted || len(r.Reads) != 3 {
+		t.Fatalf("run: want [10]=42, halted, 3 reads: got %d %v %d\n", m.Core[10].Value, m.Registers.Halted, len(r.Reads))
+	}
+
+	// step back to the start
+	for n := 0; n < steps; n++ {
+		if err := m.StepBack(); err != nil {
+			t.Fatalf("step back %d: want nil: got %v\n", n, err)
+		}
+		if n == 2 && (m.PC != 0 || len(m.RS) != 0) {
+			t.Errorf("step back %d: want pc 0: got %d %v\n", n, m.PC, m.RS)
+		}
+	}
+	if m.PC != 0 || m.A != 0 || m.C != 0 || m.Core[10].Value != 0 || m.Registers.Halted || m.Streams.Inputs[0].Offset != 0 {
+		t.Errorf("start: want zero state: got pc %d A %d C %d [10]=%d offset %d\n", m.PC, m.A, m.C, m.Core[10].Value, m.Streams.Inputs[0].Offset)
+	}
+	if err := m.StepBack(); !errors.Is(err, vm.ErrNoHistory) {
+		t.Errorf("step back: want no history: got %v\n", err)
+	}
+
+	// run forwards again, reading the same characters
+	for n := 0; n < steps; n++ {
+		if err := m.Step(nil, nil); err != nil && !errors.Is(err, vm.ErrQuit) {
+			t.Fatalf("step %d: want nil: got %v\n", n, err)
+		}
+	}
+	if m.PC != pc || m.C != c || m.Core[10].Value != 42 || len(r.Reads) != 3 {
+		t.Errorf("forwards: want pc %d C %d [10]=42 3 reads: got %d %d %d %d\n", pc, c, m.PC, m.C, m.Core[10].Value, len(r.Reads))
+	}
+
+	// replay from the saved recording
+	b := &bytes.Buffer{}
+	if _, err := r.WriteTo(b); err != nil {
+		t.Fatalf("write: want nil: got %v\n", err)
+	}
+	saved, err := vm.ReadRecording(b)
+	if err != nil {
+		t.Fatalf("read: want nil: got %v\n", err)
+	}
+	replay, err := saved.Replay()
+	if err != nil {
+		t.Fatalf("replay: want nil: got %v\n", err)
+	}
+	trace := &bytes.Buffer{}
+	replay.SetTrace(trace, vm.TraceText)
+	if err := replay.Run(nil, nil); err != nil {
+		t.Fatalf("replay: want nil: got %v\n", err)
+	}
+	if replay.Core[10].Value != 42 || replay.PC != pc || strings.Count(trace.String(), "\n") != steps {
+		t.Errorf("replay: want [10]=42 pc %d %d steps: got %d %d %d\n", pc, steps, replay.Core[10].Value, replay.PC, strings.Count(trace.String(), "\n"))
+	}
+
+	if _, err := vm.ReadRecording(strings.NewReader("LOWLSNAP")); !errors.Is(err, vm.ErrBadRecording) {
+		t.Errorf("read: want bad recording: got %v\n", err)
+	}
+}
@@ -5,16 +5,155 @@
 package vm
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
 )
 
+// DefaultMaxSteps is the step budget used when RunOptions.MaxSteps is zero.
+const DefaultMaxSteps = 10_000
+
+// RunOptions limits the resources used by RunWithOptions.
+// The zero value runs with the default step budget and no other limits.
+type RunOptions struct {
+	// Context, if not nil, is checked between steps.
+	// When it is done, the run stops and may be resumed.
+	Context context.Context
+	// MaxSteps is the number of steps allowed in this call.
+	// Zero means DefaultMaxSteps and a negative value means unlimited.
+	// When the budget is used up, Run returns ErrCycles and may be resumed.
+	MaxSteps int
+	// MaxOutput is the number of bytes the program may write to the
+	// output and message streams. Zero means unlimited.
+	MaxOutput int
+	// MaxReturnStack is the maximum depth of the return stack.
+	// Zero means unlimited.
+	MaxReturnStack int
+	// CoreFile, if set, is the name of the file that the machine is
+	// written to when the run fails with a runtime error. If the file
+	// can't be written, the error returned also wraps ErrCoreFile.
+	CoreFile string
+}
+
+// Run runs the program with the default options.
 func (m *VM) Run(fp, msg io.Writer) error {
+	return m.RunWithOptions(fp, msg, RunOptions{})
+}
+
+// RunWithOptions runs the program until it halts, quits, fails, or
+// runs out of budget.
+//
+// If the previous run stopped because the step budget was used up or
+// the context was done, the machine is not reset and the run resumes
+// from the instruction where it stopped. Otherwise, the machine is reset
+// and the program starts from the start address.
+func (m *VM) RunWithOptions(fp, msg io.Writer, opts RunOptions) error {
+	return m.coreOnError(opts, m.run(fp, msg, opts))
+}
+
+// coreOnError writes the core file named in the options if err is a
+// runtime error. It returns err, joined with the error from writing
+// the core file if that fails.
+func (m *VM) coreOnError(opts RunOptions, err error) error {
+	var re *RuntimeError
+	if opts.CoreFile != "" && errors.As(err, &re) {
+		if cerr := m.writeCoreFile(opts.CoreFile, re); cerr != nil {
+			printf(m.Streams.Messages, "vm: core: %v\n", cerr)
+			return errors.Join(err, fmt.Errorf("%w: %w", ErrCoreFile, cerr))
+		}
+	}
+	return err
+}
+
+// run implements RunWithOptions.
+func (m *VM) run(fp, msg io.Writer, opts RunOptions) error {
+	if m.Registers.Suspended {
+		m.Registers.Suspended = false
+		if fp != nil {
+			m.AddOutput("stdout", fp)
+		}
+		if msg != nil {
+			m.Streams.Messages = msg
+		}
+	} else {
+		m.Reset(fp, msg)
+		printf(m.Streams.Messages, "vm: starting %d\n", m.Registers.Start)
+	}
+
+	if err := m.loop(opts, 0); !errors.Is(err, ErrQuit) {
+		return err
+	}
+	// graceful exit; cleanup and return happy
+	return nil
+}
+
+// loop steps the machine until it halts, quits, fails, runs out of budget,
+// or the return stack is shallower than depth.
+// It returns nil only when the return stack is too shallow.
+func (m *VM) loop(opts RunOptions, depth int) error {
+	var stdout, stdmsg *limitWriter
+	if opts.MaxOutput > 0 {
+		stdout = &limitWriter{m: m, max: opts.MaxOutput}
+		stdmsg = &limitWriter{m: m, max: opts.MaxOutput}
+	}
+
+	steps := opts.MaxSteps
+	if steps == 0 {
+		steps = DefaultMaxSteps
+	}
+	for counter := 0; !m.Registers.Halted; counter++ {
+		if steps > 0 && counter == steps {
+			m.Registers.Suspended = true
+			return ErrCycles
+		}
+		if opts.Context != nil && counter%1024 == 0 {
+			if err := opts.Context.Err(); err != nil {
+				m.Registers.Suspended = true
+				return err
+			}
+		}
+
+		pc, out, msg := m.PC, m.Streams.Stdout, m.Streams.Messages
+		if stdout != nil {
+			stdout.w, stdmsg.w = out, msg
+			out, msg = stdout, stdmsg
+		}
+		if err := m.Step(out, msg); err != nil {
+			return err
+		}
+
+		if stdout != nil && (stdout.exceeded || stdmsg.exceeded) {
+			return m.runtimeError(pc, fmt.Errorf("%d bytes: %w", opts.MaxOutput, ErrOutputLimit))
+		}
+		if opts.MaxReturnStack > 0 && len(m.RS) > opts.MaxReturnStack {
+			return m.runtimeError(pc, fmt.Errorf("depth %d: %w", len(m.RS), ErrReturnStackOverflow))
+		}
+		if len(m.RS) < depth {
+			return nil
+		}
+	}
+	return ErrHalted
+}
+
+// Reset prepares the machine to run the program from the start address.
+// It sets the output streams and initializes the stack pointers.
+//
+// A non-nil fp replaces the output stream named "stdout" and a non-nil
+// msg replaces the message stream; nil keeps the existing stream, so
+// streams captured before running are not lost. The first output stream
+// is selected as the current output stream.
+func (m *VM) Reset(fp, msg io.Writer) {
 	m.PC = m.Registers.Start
-	m.Streams.Stdout = fp
-	m.Streams.Messages = msg
+	if fp != nil {
+		m.AddOutput("stdout", fp)
+	}
+	m.selectOutput(0)
+	if msg != nil {
+		m.Streams.Messages = msg
+	}
 
-	ffpt, lfpt := 0, len(m.Stack)
+	ffpt, lfpt := m.StackBounds()
 	if m.Registers.FFPT != 0 {
 		m.directStore(m.Registers.FFPT, ffpt)
 	}
@@ -22,16 +161,45 @@ func (m *VM) Run(fp, msg io.Writer) error {
 		m.directStore(m.Registers.LFPT, lfpt)
 	}
 
-	printf(m.Streams.Messages, "vm: starting %d\n", m.Registers.Start)
+	m.RS = m.RS[:0]
 	m.Registers.Halted = false
-	for counter := 10_000; !m.Registers.Halted && counter > 0; counter-- {
-		if err := m.Step(fp, msg); err != nil {
-			if !errors.Is(err, ErrQuit) {
-				return err
-			}
-			// graceful exit; cleanup and return happy
-			return nil
-		}
+	m.Registers.Suspended = false
+	m.written = 0
+}
+
+// StackBounds returns the values that Reset stores in FFPT and LFPT:
+// the base of the forwards stack and the base of the backwards stack.
+// They are the bounds of the stack region reserved by the assembler.
+// If no region was reserved, the stacks use the memory after the program,
+// up to the largest address that fits in a word.
+func (m *VM) StackBounds() (ffpt, lfpt int) {
+	if m.Registers.StackStart < m.Registers.StackEnd {
+		return m.Registers.StackStart, m.Registers.StackEnd
+	}
+	end := len(m.Core)
+	if !FitsWord(m.Registers.WordSize, end) {
+		end = 1<<m.Registers.WordSize - 1
+	}
+	return m.Registers.Last, end
+}
+
+// limitWriter counts the bytes written by the program and stops
+// writing once the limit is reached.
+type limitWriter struct {
+	m        *VM
+	w        io.Writer
+	max      int
+	exceeded bool
+}
+
+func (lw *limitWriter) Write(p []byte) (int, error) {
+	if lw.m.written+len(p) > lw.max {
+		lw.exceeded = true
+		return 0, ErrOutputLimit
+	}
+	lw.m.written += len(p)
+	if lw.w == nil {
+		return len(p), nil
 	}
-	return ErrCycles
+	return lw.w.Write(p)
 }
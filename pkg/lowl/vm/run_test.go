@@ -0,0 +1,101 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package vm_test
+
+import (
+	"context"
+	"errors"
+	"github.com/maloquacious/ml_i/pkg/lowl/op"
+	"github.com/maloquacious/ml_i/pkg/lowl/vm"
+	"testing"
+)
+
+// TestRunOptions tests the step budget, resuming, cancellation and limits.
+func TestRunOptions(t *testing.T) {
+	// an endless loop that counts in register A
+	m := &vm.VM{}
+	m.SetWord(0, vm.Word{Op: op.AAL, Value: 1})
+	m.SetWord(1, vm.Word{Op: op.GO, Value: 0})
+	if err := m.RunWithOptions(nil, nil, vm.RunOptions{MaxSteps: 10}); !errors.Is(err, vm.ErrCycles) {
+		t.Fatalf("budget: want cycles: got %v\n", err)
+	} else if m.A != 5 || m.PC != 0 {
+		t.Errorf("budget: want A 5 PC 0: got A %d PC %d\n", m.A, m.PC)
+	}
+	if err := m.RunWithOptions(nil, nil, vm.RunOptions{MaxSteps: 11}); !errors.Is(err, vm.ErrCycles) {
+		t.Fatalf("resume: want cycles: got %v\n", err)
+	} else if m.A != 11 || m.PC != 1 {
+		t.Errorf("resume: want A 11 PC 1: got A %d PC %d\n", m.A, m.PC)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.RunWithOptions(nil, nil, vm.RunOptions{Context: ctx, MaxSteps: -1}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancel: want canceled: got %v\n", err)
+	} else if m.A != 11 || m.PC != 1 {
+		t.Errorf("cancel: want A 11 PC 1: got A %d PC %d\n", m.A, m.PC)
+	}
+
+	// an endless loop that writes to the output stream
+	m = &vm.VM{}
+	stdout := m.CaptureOutput("stdout")
+	m.SetWord(0, vm.Word{Op: op.LCN, Value: 'x'})
+	m.SetWord(1, vm.Word{Op: op.MDCALL, Text: "MDOUT"})
+	m.SetWord(2, vm.Word{Op: op.GO, Value: 0})
+	err := m.RunWithOptions(nil, nil, vm.RunOptions{MaxSteps: -1, MaxOutput: 5})
+	var re *vm.RuntimeError
+	if !errors.Is(err, vm.ErrOutputLimit) {
+		t.Fatalf("output: want limit: got %v\n", err)
+	} else if !errors.As(err, &re) || re.PC != 1 {
+		t.Errorf("output: want runtime error at 1: got %v\n", err)
+	}
+	if got := stdout.String(); got != "xxxxx" {
+		t.Errorf("output: want %q: got %q\n", "xxxxx", got)
+	}
+
+	// endless recursion
+	m = &vm.VM{}
+	m.SetWord(0, vm.Word{Op: op.GOSUB, Value: 0})
+	if err := m.RunWithOptions(nil, nil, vm.RunOptions{MaxSteps: -1, MaxReturnStack: 100}); !errors.Is(err, vm.ErrReturnStackOverflow) {
+		t.Fatalf("recursion: want overflow: got %v\n", err)
+	} else if len(m.RS) != 101 {
+		t.Errorf("recursion: want depth 101: got %d\n", len(m.RS))
+	}
+}
+
+// TestStackRegion tests that Reset points the stacks at the stack region.
+func TestStackRegion(t *testing.T) {
+	m := vm.New()
+	m.Registers.Last, m.Registers.StackStart, m.Registers.StackEnd = 10, 10, 30
+	m.Reset(nil, nil)
+	if ffpt, lfpt := m.Core[m.Registers.FFPT].Value, m.Core[m.Registers.LFPT].Value; ffpt != 10 || lfpt != 30 {
+		t.Errorf("region: want 10 30: got %d %d\n", ffpt, lfpt)
+	}
+
+	// without a region, the stacks use the memory after the program
+	m.Registers.StackStart, m.Registers.StackEnd = 0, 0
+	m.Reset(nil, nil)
+	if ffpt, lfpt := m.Core[m.Registers.FFPT].Value, m.Core[m.Registers.LFPT].Value; ffpt != 10 || lfpt != vm.MAX_WORDS {
+		t.Errorf("no region: want 10 %d: got %d %d\n", vm.MAX_WORDS, ffpt, lfpt)
+	}
+
+	// with 16-bit words, the backwards stack starts at the largest 16-bit address
+	_ = m.SetWordSize(16)
+	m.Reset(nil, nil)
+	if _, lfpt := m.StackBounds(); lfpt != 65535 {
+		t.Errorf("16 bits: want 65535: got %d\n", lfpt)
+	}
+	// FSTK stacks 7 and leaves the new FFPT, 11, in A for BSTK to stack
+	m.A = 7
+	m.SetWord(8, vm.Word{Op: op.FSTK})
+	m.SetWord(9, vm.Word{Op: op.BSTK})
+	m.PC = 8
+	for _, name := range []string{"FSTK", "BSTK"} {
+		if err := m.Step(nil, nil); err != nil {
+			t.Fatalf("16 bits: %s: want nil: got %v\n", name, err)
+		}
+	}
+	if m.Core[10].Value != 7 || m.Core[65534].Value != 11 {
+		t.Errorf("16 bits: want 7 and 11: got %d and %d\n", m.Core[10].Value, m.Core[65534].Value)
+	}
+}
@@ -0,0 +1,150 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package vm
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"io"
+)
+
+// SnapshotVersion is the version of the snapshot format written by Snapshot.
+// Restore rejects snapshots written with any other version.
+const SnapshotVersion = 2
+
+// snapshotMagic starts every snapshot.
+const snapshotMagic = "LOWLSNAP"
+
+// snapshotHeader follows the magic string.
+type snapshotHeader struct {
+	Version int
+}
+
+// snapshotWord is a word of Core that is not the zero Word.
+type snapshotWord struct {
+	Address int
+	Word    Word
+}
+
+// snapshotStream records the name and position of a stream.
+// The readers and writers themselves can't be saved.
+type snapshotStream struct {
+	Name   string
+	Offset int
+}
+
+// snapshotState is the machine state, except for the Registers block,
+// which is encoded after it.
+type snapshotState struct {
+	Name    string
+	PC      int
+	A, B, C int
+	Core    []snapshotWord
+	RS      []int
+	Symbols map[string]int
+	Inputs  []snapshotStream
+	Input   int
+	Outputs []snapshotStream
+	Output  int
+}
+
+// Snapshot writes the state of the machine to w: the words in Core,
+// including their source information and the stack region, the registers,
+// the Registers block, the return stack, and the names and positions of the streams.
+func (m *VM) Snapshot(w io.Writer) error {
+	state := snapshotState{
+		Name:    m.Name,
+		PC:      m.PC,
+		A:       m.A,
+		B:       m.B,
+		C:       m.C,
+		RS:      m.RS,
+		Symbols: m.Symbols,
+		Input:   m.Streams.Input,
+		Output:  m.Streams.Output,
+	}
+	for address, word := range m.Core {
+		if word != (Word{}) {
+			state.Core = append(state.Core, snapshotWord{Address: address, Word: word})
+		}
+	}
+	for _, in := range m.Streams.Inputs {
+		state.Inputs = append(state.Inputs, snapshotStream{Name: in.Name, Offset: in.Offset})
+	}
+	for _, out := range m.Streams.Outputs {
+		state.Outputs = append(state.Outputs, snapshotStream{Name: out.Name})
+	}
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(snapshotMagic); err != nil {
+		return err
+	}
+	enc := gob.NewEncoder(bw)
+	if err := enc.Encode(snapshotHeader{Version: SnapshotVersion}); err != nil {
+		return err
+	} else if err = enc.Encode(state); err != nil {
+		return err
+	} else if err = enc.Encode(m.Registers); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Restore reads a snapshot written by Snapshot and returns the machine.
+//
+// The streams are restored by name and position, but without readers or
+// writers; the caller adds them again with AddInput and AddOutput. Unless
+// the machine had halted, the next run resumes from the saved PC.
+func Restore(r io.Reader) (*VM, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != snapshotMagic {
+		return nil, ErrBadSnapshot
+	}
+	dec := gob.NewDecoder(br)
+	var header snapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("header: %v: %w", err, ErrBadSnapshot)
+	} else if header.Version != SnapshotVersion {
+		return nil, fmt.Errorf("version %d: want %d: %w", header.Version, SnapshotVersion, ErrSnapshotVersion)
+	}
+
+	var state snapshotState
+	m := &VM{}
+	if err := dec.Decode(&state); err != nil {
+		return nil, fmt.Errorf("state: %v: %w", err, ErrBadSnapshot)
+	} else if err = dec.Decode(&m.Registers); err != nil {
+		return nil, fmt.Errorf("registers: %v: %w", err, ErrBadSnapshot)
+	}
+	// Reset stores the stack bounds in FFPT and LFPT, so they must be in Core.
+	for _, v := range []int{m.Registers.FFPT, m.Registers.LFPT} {
+		if v < 0 || v >= len(m.Core) {
+			return nil, fmt.Errorf("stack pointer address %d: %w", v, ErrBadSnapshot)
+		}
+	}
+
+	m.Name, m.PC, m.A, m.B, m.C = state.Name, state.PC, state.A, state.B, state.C
+	for _, w := range state.Core {
+		if w.Address < 0 || w.Address >= len(m.Core) {
+			return nil, fmt.Errorf("core address %d: %w", w.Address, ErrBadSnapshot)
+		}
+		m.Core[w.Address] = w.Word
+	}
+	m.RS, m.Symbols = state.RS, state.Symbols
+	for _, in := range state.Inputs {
+		m.Streams.Inputs = append(m.Streams.Inputs, &Input{Name: in.Name, Offset: in.Offset})
+	}
+	m.selectInput(state.Input)
+	for _, out := range state.Outputs {
+		m.Streams.Outputs = append(m.Streams.Outputs, &Output{Name: out.Name})
+	}
+	m.selectOutput(state.Output)
+
+	if !m.Registers.Halted {
+		m.Registers.Suspended = true
+	}
+	return m, nil
+}
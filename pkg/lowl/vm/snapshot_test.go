@@ -0,0 +1,96 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package vm_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"github.com/maloquacious/ml_i/pkg/lowl/op"
+	"github.com/maloquacious/ml_i/pkg/lowl/vm"
+	"strings"
+	"testing"
+)
+
+// TestSnapshot tests that a restored machine continues where the snapshot was taken.
+func TestSnapshot(t *testing.T) {
+	// copy the input to the output, counting characters in B
+	m := &vm.VM{Name: "COPY"}
+	m.SetWord(0, vm.Word{Op: op.MDCALL, Text: "MDREAD"})
+	m.SetWord(1, vm.Word{Op: op.GOTBL, Value: 5, ValueTwo: 2})
+	m.SetWord(2, vm.Word{Op: op.MDCALL, Text: "MDOUT"})
+	m.SetWord(3, vm.Word{Op: op.SBL, Value: -1})
+	m.SetWord(4, vm.Word{Op: op.GO, Value: 0})
+	m.SetWord(5, vm.Word{Op: op.MDCALL, Text: "MDQUIT"})
+	m.Core[3].Source.Line, m.Core[3].Source.Parameters = 4, "-1"
+	m.Symbols = map[string]int{"LOOP": 0}
+	m.SetInput(strings.NewReader("hello"))
+	first := m.CaptureOutput("stdout")
+	if err := m.RunWithOptions(nil, nil, vm.RunOptions{MaxSteps: 10}); !errors.Is(err, vm.ErrCycles) {
+		t.Fatalf("run: want cycles: got %v\n", err)
+	}
+	if m.Streams.Inputs[0].Offset != 2 {
+		t.Errorf("offset: want 2: got %d\n", m.Streams.Inputs[0].Offset)
+	}
+
+	b := &bytes.Buffer{}
+	if err := m.Snapshot(b); err != nil {
+		t.Fatalf("snapshot: want nil: got %v\n", err)
+	}
+	r, err := vm.Restore(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatalf("restore: want nil: got %v\n", err)
+	}
+	if r.Name != "COPY" || r.PC != m.PC || r.B != m.B || r.Core[3] != m.Core[3] || r.Symbols["LOOP"] != 0 || !r.Registers.Suspended {
+		t.Errorf("restore: want COPY pc %d B %d: got %s pc %d B %d\n", m.PC, m.B, r.Name, r.PC, r.B)
+	}
+	if len(r.Streams.Inputs) != 1 || r.Streams.Inputs[0].Name != "stdin" || r.Streams.Inputs[0].Offset != 2 {
+		t.Errorf("restore: want stdin at 2: got %+v\n", r.Streams.Inputs)
+	}
+
+	// both machines finish the same way from the same input
+	r.AddInputAt("stdin", strings.NewReader("llo"), 2)
+	second := r.CaptureOutput("stdout")
+
+	// a snapshot of the restored machine records the position in the whole input
+	if err := r.RunWithOptions(nil, nil, vm.RunOptions{MaxSteps: 5}); !errors.Is(err, vm.ErrCycles) {
+		t.Fatalf("run restored: want cycles: got %v\n", err)
+	}
+	b2 := &bytes.Buffer{}
+	if err := r.Snapshot(b2); err != nil {
+		t.Fatalf("snapshot restored: want nil: got %v\n", err)
+	} else if r2, err := vm.Restore(b2); err != nil {
+		t.Fatalf("restore again: want nil: got %v\n", err)
+	} else if r2.Streams.Inputs[0].Offset != 3 {
+		t.Errorf("restore again: want offset 3: got %d\n", r2.Streams.Inputs[0].Offset)
+	}
+	if err := m.Run(nil, nil); err != nil {
+		t.Fatalf("run: want nil: got %v\n", err)
+	} else if err = r.Run(nil, nil); err != nil {
+		t.Fatalf("run restored: want nil: got %v\n", err)
+	}
+	if first.String() != "hello" || second.String() != "llo" || r.B != 5 {
+		t.Errorf("run: want hello, llo, B 5: got %q, %q, B %d\n", first.String(), second.String(), r.B)
+	}
+
+	if _, err := vm.Restore(strings.NewReader("not a snapshot")); !errors.Is(err, vm.ErrBadSnapshot) {
+		t.Errorf("restore: want bad snapshot: got %v\n", err)
+	}
+	if _, err := vm.Restore(bytes.NewReader(b.Bytes()[:len("LOWLSNAP")+3])); !errors.Is(err, vm.ErrBadSnapshot) {
+		t.Errorf("restore: want bad snapshot: got %v\n", err)
+	}
+	r.Registers.LFPT = len(r.Core)
+	b = &bytes.Buffer{}
+	if err := r.Snapshot(b); err != nil {
+		t.Fatalf("snapshot bad LFPT: want nil: got %v\n", err)
+	} else if _, err = vm.Restore(b); !errors.Is(err, vm.ErrBadSnapshot) {
+		t.Errorf("restore bad LFPT: want bad snapshot: got %v\n", err)
+	}
+	b = bytes.NewBufferString("LOWLSNAP")
+	_ = gob.NewEncoder(b).Encode(struct{ Version int }{vm.SnapshotVersion + 1})
+	if _, err := vm.Restore(b); !errors.Is(err, vm.ErrSnapshotVersion) {
+		t.Errorf("restore: want version: got %v\n", err)
+	}
+}
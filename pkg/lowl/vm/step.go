@@ -5,36 +5,105 @@
 package vm
 
 import (
+	"errors"
 	"fmt"
 	"github.com/maloquacious/ml_i/pkg/lowl/op"
 	"io"
 	"strings"
 )
 
+// Step executes the instruction at PC.
+// It returns ErrHalted or ErrQuit when the machine stops.
+// Any other error is returned as a *RuntimeError.
 func (m *VM) Step(stdout, stderr io.Writer) error {
 	if m.Registers.Halted {
 		return ErrHalted
 	}
 
-	w := m.Core[m.PC]
+	pc := m.PC
+	if m.tracer != nil {
+		m.tracer.before(m)
+	}
+	if m.profile != nil {
+		m.profile.before(m)
+	}
+	if m.coverage != nil {
+		m.coverage.Hits[pc]++
+	}
+	if m.recording != nil {
+		m.recording.before(m)
+	}
+	if m.Hooks != nil {
+		m.Hooks.before(m, pc)
+	}
+	err := m.checkedStep(stdout, stderr)
+	if m.Registers.WordSize != 0 {
+		m.wrapRegisters()
+	}
+	if err != nil && !errors.Is(err, ErrHalted) && !errors.Is(err, ErrQuit) {
+		err = m.runtimeError(pc, err)
+	}
+	if m.tracer != nil {
+		m.tracer.after(m, err)
+	}
+	if m.profile != nil {
+		m.profile.after(m)
+	}
+	if m.recording != nil {
+		m.recording.after()
+	}
+	if m.Hooks != nil {
+		m.Hooks.after(m, err)
+	}
+	return err
+}
+
+// checkedStep calls step, turning any address fault into an error.
+func (m *VM) checkedStep(stdout, stderr io.Writer) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			f, ok := r.(addressFault)
+			if !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("address %d: %w", f.address, ErrAddressOutOfRange)
+		}
+	}()
+	return m.step(stdout, stderr)
+}
+
+// step implements Step.
+func (m *VM) step(stdout, stderr io.Writer) error {
+	w := m.Core[m.check(m.PC)]
 	m.PC = m.PC + 1
 
-	memcpy := func(src, dst, length int) {
+	// move copies the field one word at a time, starting with the first
+	// word when moving forwards and with the last word when moving backwards.
+	// When the fields overlap, words may be copied more than once, just as
+	// they are by the LOWL definitions of FMOVE and BMOVE.
+	move := func(src, dst, length int, backwards bool) {
 		// SRCPT points at the start of the source field.
 		// DSTPT points to the start of the destination field.
 		// Register A contains the length of the field (number of words to move)
-		tmp := make([]Word, length, length)
-		for offset := 0; offset < length; offset++ {
-			tmp[offset] = m.Core[src+offset]
+		if length > 0 {
+			src, dst = m.check(src), m.check(dst)
 		}
-		for offset := 0; offset < length; offset++ {
-			m.Core[dst+offset] = tmp[offset]
+		m.checkRange(src, length)
+		m.checkRange(dst, length)
+		for n := 0; n < length; n++ {
+			offset := n
+			if backwards {
+				offset = length - 1 - n
+			}
+			m.saveWord(dst + offset)
+			m.Core[dst+offset] = m.Core[src+offset]
+			m.noteWrite(dst+offset, m.Core[dst+offset].Value)
 		}
 	}
 
 	switch w.Op {
 	case op.AAL: // add a literal value to register A
-		literalValue := w.Value
+		literalValue := m.wrap(w.Value)
 		m.A = m.A + literalValue
 	case op.AAV: // add a variable to register A
 		variableAddress := w.Value
@@ -44,8 +113,15 @@ func (m *VM) Step(stdout, stderr io.Writer) error {
 		variableAddress := w.Value
 		variableValue := m.directLoad(variableAddress)
 		m.B = m.B + variableValue
+	case op.ALIGN: // round register A up to the next number boundary
+		// a character address is rounded up to an address that can hold a number.
+		if boundary := m.Registers.LNM; boundary > 1 && boundary > m.Registers.LCH {
+			if rem := (m.A%boundary + boundary) % boundary; rem != 0 {
+				m.A = m.A + boundary - rem
+			}
+		}
 	case op.ANDL: // bitwise "AND" a literal value with register A
-		literalValue := w.Value
+		literalValue := m.wrap(w.Value)
 		m.A = m.A & literalValue
 	case op.ANDV: // bitwise AND a variable with register A
 		variableAddress := w.Value
@@ -55,7 +131,7 @@ func (m *VM) Step(stdout, stderr io.Writer) error {
 		// SRCPT points at the start of the source field.
 		// DSTPT points to the start of the destination field.
 		// Register A contains the length of the field (number of words to move)
-		memcpy(m.directLoad(w.Value), m.directLoad(w.ValueTwo), m.A)
+		move(m.directLoad(w.Value), m.directLoad(w.ValueTwo), m.A, true)
 	case op.BSTK: // stack A on backwards stack
 		// preserve A
 		a := m.A
@@ -81,45 +157,54 @@ func (m *VM) Step(stdout, stderr io.Writer) error {
 		valueToStore := m.A
 		variableAddress = m.Registers.LFPT
 		m.indirectStore(variableAddress, valueToStore)
+		m.pushed(BackwardsStack, m.directLoad(variableAddress), valueToStore)
 
 		// LAV   FFPT     // load A with value of FFPT
 		variableAddress = m.Registers.FFPT
 		variableValue = m.directLoad(variableAddress)
 		m.A = variableValue
 
-		// CAV   LFPT     // compare A with the value of LFPT
+		// CAV   LFPT,A   // compare A with the value of LFPT
 		variableAddress = m.Registers.LFPT
 		variableValue = m.directLoad(variableAddress)
-		m.compare(m.A, variableValue)
+		m.compareAddresses(m.A, variableValue)
 
 		// GOGE  ERLSO    // if EQ or GT, error
 		if m.Registers.Cmp == IS_EQ || m.Registers.Cmp == IS_GR { // ERLSO
-			return fmt.Errorf("%d: BS: %w", m.PC-1, ErrStackOverflow)
+			return m.StackOverflow("BS")
 		}
 	case op.BUMP: // increase a variable by a literal value
-		literalValue := w.ValueTwo
+		literalValue := m.wrap(w.ValueTwo)
 		variableAddress := w.Value
 		variableValue := m.directLoad(variableAddress)
 		m.directStore(variableAddress, literalValue+variableValue)
 	case op.CAI: // compare contents of address pointed to by V to register A
 		variableAddress := w.Value
 		indirectValue := m.indirectLoad(variableAddress)
-		m.compare(m.A, indirectValue)
+		if w.ValueTwo == CompareAddresses {
+			m.compareAddresses(m.A, indirectValue)
+		} else {
+			m.compare(m.A, indirectValue)
+		}
 	case op.CAL: // compare register A with a literal value
-		literalValue := w.Value
+		literalValue := m.wrap(w.Value)
 		m.compare(m.A, literalValue)
 	case op.CAV: // compare A with the value of variable
 		variableAddress := w.Value
 		variableValue := m.directLoad(variableAddress)
-		m.compare(m.A, variableValue)
+		if w.ValueTwo == CompareAddresses {
+			m.compareAddresses(m.A, variableValue)
+		} else {
+			m.compare(m.A, variableValue)
+		}
 	case op.CCI: // compare contents of address pointed to by V to register C
 		variableAddress := w.Value
 		indirectValue := m.indirectLoad(variableAddress)
 		m.compare(m.C, indirectValue)
 	case op.CCL: // compare register C with a literal value
-		m.compare(m.C, w.Value)
+		m.compare(m.C, m.wrap(w.Value))
 	case op.CCN: // compare register C with named character
-		literalValue := w.Value
+		literalValue := m.wrap(w.Value)
 		m.compare(m.C, literalValue)
 	case op.CFSTK: // stack C on forwards stack
 		// CSTK is implemented as FSTK except C is stored and FFPT is incremented by OF(LCH)
@@ -127,6 +212,7 @@ func (m *VM) Step(stdout, stderr io.Writer) error {
 		valueToStore := m.C
 		variableAddress := m.Registers.FFPT
 		m.indirectStore(variableAddress, valueToStore)
+		m.pushed(ForwardsStack, m.directLoad(variableAddress), valueToStore)
 
 		// LAV   FFPT     // load A with value of FFPT
 		variableAddress = m.Registers.FFPT
@@ -134,7 +220,7 @@ func (m *VM) Step(stdout, stderr io.Writer) error {
 		m.A = variableValue
 
 		// AAL   OF(LCH)  // add LCH to register A
-		literalValue := m.Registers.LNM
+		literalValue := m.Registers.LCH
 		m.A = m.A + literalValue
 
 		// STV   FFPT     // store register A in FFPT
@@ -142,38 +228,46 @@ func (m *VM) Step(stdout, stderr io.Writer) error {
 		variableValue = m.A
 		m.directStore(variableAddress, variableValue)
 
-		// CAV   LFPT     // compare A with the value of LFPT
+		// CAV   LFPT,A   // compare A with the value of LFPT
 		variableAddress = m.Registers.LFPT
 		variableValue = m.directLoad(variableAddress)
-		m.compare(m.A, variableValue)
+		m.compareAddresses(m.A, variableValue)
 
 		// GOGE  ERLSO    // if EQ or GT, error
 		if m.Registers.Cmp == IS_EQ || m.Registers.Cmp == IS_GR { // ERLSO
-			return fmt.Errorf("%d: FS: %w", m.PC-1, ErrStackOverflow)
+			return m.StackOverflow("FS")
 		}
 	case op.CLEAR: // set variable to zero
 		variableAddress := w.Value
 		m.directStore(variableAddress, 0)
 	case op.CSS: // pop address of the subroutine stack
 		if len(m.RS) == 0 {
-			return fmt.Errorf("%d: RS: %w", m.PC-1, ErrStackUnderflow)
+			return ErrReturnStackUnderflow
 		}
 		m.RS = m.RS[:len(m.RS)-1]
 	case op.EXIT: // exit from subroutine
+		if len(m.RS) == 0 {
+			return ErrReturnStackUnderflow
+		}
 		// pop the return address from the stack
+		pc := m.PC - 1
 		m.PC, m.RS = m.RS[len(m.RS)-1], m.RS[:len(m.RS)-1]
 		// update the test register used by GOADD and GOBRPC
 		m.Registers.JumpValue = w.Value
+		if m.Hooks != nil && m.Hooks.OnExit != nil {
+			m.Hooks.OnExit(m, pc, w.Value)
+		}
 	case op.FMOVE: // forwards block move
 		// SRCPT points at the start of the source field.
 		// DSTPT points to the start of the destination field.
 		// Register A contains the length of the field (number of words to move)
-		memcpy(m.directLoad(w.Value), m.directLoad(w.ValueTwo), m.A)
+		move(m.directLoad(w.Value), m.directLoad(w.ValueTwo), m.A, false)
 	case op.FSTK: // stack A on forwards stack
 		// STI   FFPT     // store A in address pointed at by FFPT
 		valueToStore := m.A
 		variableAddress := m.Registers.FFPT
 		m.indirectStore(variableAddress, valueToStore)
+		m.pushed(ForwardsStack, m.directLoad(variableAddress), valueToStore)
 
 		// LAV   FFPT     // load A with value of FFPT
 		variableAddress = m.Registers.FFPT
@@ -189,14 +283,14 @@ func (m *VM) Step(stdout, stderr io.Writer) error {
 		variableValue = m.A
 		m.directStore(variableAddress, variableValue)
 
-		// CAV   LFPT     // compare A with the value of LFPT
+		// CAV   LFPT,A   // compare A with the value of LFPT
 		variableAddress = m.Registers.LFPT
 		variableValue = m.directLoad(variableAddress)
-		m.compare(m.A, variableValue)
+		m.compareAddresses(m.A, variableValue)
 
 		// GOGE  ERLSO    // if EQ or GT, error
 		if m.Registers.Cmp == IS_EQ || m.Registers.Cmp == IS_GR { // ERLSO
-			return fmt.Errorf("%d: FS: %w", m.PC-1, ErrStackOverflow)
+			return m.StackOverflow("FS")
 		}
 	case op.GO: // unconditional branch
 		m.PC = w.Value
@@ -241,6 +335,9 @@ func (m *VM) Step(stdout, stderr io.Writer) error {
 		m.RS = append(m.RS, m.PC)
 		// go to the subroutine
 		m.PC = w.Value
+		if m.Hooks != nil && m.Hooks.OnCall != nil {
+			m.Hooks.OnCall(m, m.RS[len(m.RS)-1]-1, w.Value, m.A)
+		}
 	case op.GOTBL: // jump table for exit instructions
 		if w.ValueTwo == m.Registers.JumpValue {
 			m.PC = w.Value
@@ -259,10 +356,10 @@ func (m *VM) Step(stdout, stderr io.Writer) error {
 		indirectValue := m.indirectLoad(variableAddress)
 		m.A = indirectValue
 	case op.LAL: // load literal value into register A
-		literalValue := w.Value
+		literalValue := m.wrap(w.Value)
 		m.A = literalValue
 	case op.LAM: // load contents of address pointed to by register B + N-OF into register A
-		literalValue := w.Value
+		literalValue := m.wrap(w.Value)
 		indexedValue := m.indexedLoad(literalValue)
 		m.A = indexedValue
 	case op.LAV: // load A with value of variable V
@@ -278,40 +375,44 @@ func (m *VM) Step(stdout, stderr io.Writer) error {
 		indirectValue := m.indirectLoad(variableAddress)
 		m.C = indirectValue
 	case op.LCM: // load contents of address pointed to by register B + N-OF into register A
-		literalValue := w.Value
+		literalValue := m.wrap(w.Value)
 		indexedValue := m.indexedLoad(literalValue)
 		m.C = indexedValue
 	case op.LCN: // load C with named character
-		literalValue := w.Value
+		literalValue := m.wrap(w.Value)
 		m.C = literalValue
-	case op.MDERCH: // copy register C to output stream
-		if m.C == '$' {
-			printf(stdout, "\n")
-		} else {
-			printf(stdout, "%s", string(byte(m.C)))
+	case op.MDCALL: // call a machine-dependent subroutine
+		fn, ok := LookupMD(w.Text)
+		if !ok {
+			return fmt.Errorf("%s: %w", w.Text, ErrNotImplemented)
+		}
+		exit, err := fn(m, stdout, stderr)
+		if err != nil {
+			return err
 		}
+		// update the test register used by GOTBL, just as EXIT does
+		m.Registers.JumpValue = exit
+	case op.MDERCH: // copy register C to message stream
+		_, _ = mdErch(m, stdout, stderr)
 	case op.MDQUIT: // graceful exit requested
-		// force the program counter back to this instruction
-		m.PC = m.PC - 1
-		// signal that we have stopped the machine
-		m.Registers.Halted = true
-		return ErrQuit
-	case op.MESS: // copy text to output stream
-		printf(stdout, "%s", strings.ReplaceAll(w.Text, "$", "\n"))
+		_, err := mdQuit(m, stdout, stderr)
+		return err
+	case op.MESS: // copy text to message stream
+		m.output(stderr, strings.ReplaceAll(w.Text, "$", "\n"), true)
 	case op.MULTL: // multiply register A by a literal value
-		literalValue := w.Value
+		literalValue := m.wrap(w.Value)
 		m.A = m.A * literalValue
 	case op.NOOP: // noop
 		// do nothing
 	case op.SAL: // subtract a literal value from register A
-		literalValue := w.Value
+		literalValue := m.wrap(w.Value)
 		m.A = m.A - literalValue
 	case op.SAV: // subtract a variable from register A
 		variableAddress := w.Value
 		variableValue := m.directLoad(variableAddress)
 		m.A = m.A - variableValue
 	case op.SBL: // subtract a literal value from register B
-		literalValue := w.Value
+		literalValue := m.wrap(w.Value)
 		m.B = m.B - literalValue
 	case op.SBV: // subtract a variable from register B
 		variableAddress := w.Value
@@ -328,10 +429,16 @@ func (m *VM) Step(stdout, stderr io.Writer) error {
 	case op.UNSTK: // unstack from backwards stack
 		parmVariableAddress := w.Value
 
+		// the backwards stack is empty when LFPT is at its base
+		if _, base := m.StackBounds(); m.Unsigned(m.directLoad(m.Registers.LFPT)) >= m.Unsigned(base) {
+			return fmt.Errorf("US: %w", ErrStackUnderflow)
+		}
+
 		// LAI  LFPT         // load A with contents of the address pointed to by variable V
 		variableAddress := m.Registers.LFPT
 		indirectValue := m.indirectLoad(variableAddress)
 		m.A = indirectValue
+		m.popped(BackwardsStack, m.directLoad(variableAddress), indirectValue)
 
 		// STV  V             // store register A in variable
 		variableAddress = parmVariableAddress
@@ -349,3 +456,15 @@ func (m *VM) Step(stdout, stderr io.Writer) error {
 
 	return nil
 }
+
+// StackOverflow branches to the program's stack overflow handler, ERLSO,
+// as the LOWL definitions of the stacking instructions do. If the program
+// has no handler, it returns ErrStackOverflow. MD subroutines that stack
+// values call it, with their name, when the stacks collide.
+func (m *VM) StackOverflow(name string) error {
+	if m.Registers.ERLSO == 0 {
+		return fmt.Errorf("%s: %w", name, ErrStackOverflow)
+	}
+	m.PC = m.Registers.ERLSO
+	return nil
+}
@@ -0,0 +1,141 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Input is a named character input stream.
+type Input struct {
+	Name   string
+	Reader *bufio.Reader
+	Offset int // number of bytes read by the program
+}
+
+// Output is a named character output stream.
+type Output struct {
+	Name   string
+	Writer io.Writer
+}
+
+// SetInput replaces all the input streams with a single stream
+// named "stdin" and selects it. A nil reader removes all input.
+func (m *VM) SetInput(r io.Reader) {
+	m.Streams.Stdin, m.Streams.Inputs, m.Streams.Input = nil, nil, 0
+	if r != nil {
+		m.AddInput("stdin", r)
+	}
+}
+
+// AddInput adds a named input stream and returns its stream number.
+// If a stream with that name already exists, it is replaced.
+// The first stream added becomes the current input stream.
+func (m *VM) AddInput(name string, r io.Reader) int {
+	return m.AddInputAt(name, r, 0)
+}
+
+// AddInputAt is AddInput for a reader that is already offset bytes into
+// the stream, such as a file reopened to continue a restored machine.
+// The offset is kept so that later snapshots record the right position.
+func (m *VM) AddInputAt(name string, r io.Reader, offset int) int {
+	in := &Input{Name: name, Reader: bufio.NewReader(r), Offset: offset}
+	for n, stream := range m.Streams.Inputs {
+		if stream.Name == name {
+			m.Streams.Inputs[n] = in
+			if m.Streams.Input == n {
+				m.Streams.Stdin = in.Reader
+			}
+			return n
+		}
+	}
+	m.Streams.Inputs = append(m.Streams.Inputs, in)
+	if len(m.Streams.Inputs) == 1 {
+		m.selectInput(0)
+	}
+	return len(m.Streams.Inputs) - 1
+}
+
+// SelectInput makes the named stream the current input stream.
+func (m *VM) SelectInput(name string) error {
+	for n, stream := range m.Streams.Inputs {
+		if stream.Name == name {
+			m.selectInput(n)
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: %w", name, ErrUnknownStream)
+}
+
+// selectInput makes stream number n the current input stream.
+// Returns false if there is no such stream.
+func (m *VM) selectInput(n int) bool {
+	if n < 0 || n >= len(m.Streams.Inputs) {
+		return false
+	}
+	m.Streams.Input, m.Streams.Stdin = n, m.Streams.Inputs[n].Reader
+	return true
+}
+
+// AddOutput adds a named output stream and returns its stream number.
+// If a stream with that name already exists, it is replaced.
+// The first stream added becomes the current output stream.
+func (m *VM) AddOutput(name string, w io.Writer) int {
+	out := &Output{Name: name, Writer: w}
+	for n, stream := range m.Streams.Outputs {
+		if stream.Name == name {
+			m.Streams.Outputs[n] = out
+			if m.Streams.Output == n {
+				m.Streams.Stdout = w
+			}
+			return n
+		}
+	}
+	m.Streams.Outputs = append(m.Streams.Outputs, out)
+	if len(m.Streams.Outputs) == 1 {
+		m.selectOutput(0)
+	}
+	return len(m.Streams.Outputs) - 1
+}
+
+// SelectOutput makes the named stream the current output stream.
+func (m *VM) SelectOutput(name string) error {
+	for n, stream := range m.Streams.Outputs {
+		if stream.Name == name {
+			m.selectOutput(n)
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: %w", name, ErrUnknownStream)
+}
+
+// selectOutput makes stream number n the current output stream.
+// Returns false if there is no such stream.
+func (m *VM) selectOutput(n int) bool {
+	if n < 0 || n >= len(m.Streams.Outputs) {
+		return false
+	}
+	m.Streams.Output, m.Streams.Stdout = n, m.Streams.Outputs[n].Writer
+	return true
+}
+
+// CaptureOutput adds (or replaces) a named output stream that writes
+// to a buffer and returns the buffer. It is intended for tests.
+func (m *VM) CaptureOutput(name string) *bytes.Buffer {
+	b := &bytes.Buffer{}
+	m.AddOutput(name, b)
+	return b
+}
+
+// CaptureMessages replaces the message stream with a buffer and returns
+// the buffer. It is intended for tests.
+func (m *VM) CaptureMessages() *bytes.Buffer {
+	b := &bytes.Buffer{}
+	m.Streams.Messages = b
+	return b
+}
@@ -0,0 +1,124 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package vm_test
+
+import (
+	"errors"
+	"github.com/maloquacious/ml_i/pkg/lowl/op"
+	"github.com/maloquacious/ml_i/pkg/lowl/vm"
+	"strings"
+	"testing"
+)
+
+// TestInput tests reading from and selecting input streams.
+func TestInput(t *testing.T) {
+	m := &vm.VM{}
+	if n := m.AddInput("first", strings.NewReader("ab")); n != 0 {
+		t.Errorf("add: first: want 0: got %d\n", n)
+	}
+	if n := m.AddInput("second", strings.NewReader("x")); n != 1 {
+		t.Errorf("add: second: want 1: got %d\n", n)
+	}
+
+	// read, select and read again.
+	for pc, name := range []string{"MDREAD", "MDSELI", "MDREAD", "MDREAD", "MDREAD", "MDSELI", "MDREAD", "MDSELI"} {
+		m.SetWord(pc, vm.Word{Op: op.MDCALL, Text: name})
+	}
+	for n, tc := range []struct {
+		a    int
+		c    int
+		exit int
+	}{
+		{a: 1, c: 'a', exit: 1},    // MDREAD from first
+		{a: 1, c: 'a', exit: 1},    // MDSELI second
+		{a: 1, c: 'x', exit: 1},    // MDREAD from second
+		{a: 0, c: vm.EOF, exit: 2}, // MDREAD at end of second
+		{a: 0, c: vm.EOF, exit: 2}, // MDREAD stays at end of input
+		{a: 0, c: vm.EOF, exit: 1}, // MDSELI first
+		{a: 9, c: 'b', exit: 1},    // MDREAD from first
+		{a: 9, c: 'b', exit: 2},    // MDSELI no such stream
+	} {
+		m.A = tc.a
+		if err := m.Step(nil, nil); err != nil {
+			t.Fatalf("%d: want nil: got %v\n", n, err)
+		}
+		if m.C != tc.c {
+			t.Errorf("%d: c: want %d: got %d\n", n, tc.c, m.C)
+		}
+		if m.Registers.JumpValue != tc.exit {
+			t.Errorf("%d: exit: want %d: got %d\n", n, tc.exit, m.Registers.JumpValue)
+		}
+	}
+
+	// named selection
+	m.SetInput(strings.NewReader("z"))
+	if len(m.Streams.Inputs) != 1 || m.Streams.Inputs[0].Name != "stdin" {
+		t.Errorf("set: want [stdin]: got %d streams\n", len(m.Streams.Inputs))
+	}
+	if err := m.SelectInput("first"); !errors.Is(err, vm.ErrUnknownStream) {
+		t.Errorf("select: want unknown stream: got %v\n", err)
+	}
+	m.PC = 0
+	if err := m.Step(nil, nil); err != nil {
+		t.Fatalf("read: want nil: got %v\n", err)
+	} else if m.C != 'z' {
+		t.Errorf("read: c: want %d: got %d\n", 'z', m.C)
+	}
+
+	// no input at all reads as end of input
+	m.SetInput(nil)
+	m.PC = 0
+	if err := m.Step(nil, nil); err != nil {
+		t.Fatalf("read: want nil: got %v\n", err)
+	} else if m.C != vm.EOF {
+		t.Errorf("read: c: want %d: got %d\n", vm.EOF, m.C)
+	}
+}
+
+// TestOutput tests writing to and selecting output streams.
+func TestOutput(t *testing.T) {
+	m := &vm.VM{}
+	stdout, listing, messages := m.CaptureOutput("stdout"), m.CaptureOutput("listing"), m.CaptureMessages()
+	for pc, w := range []vm.Word{
+		{Op: op.LCN, Value: 'a'},
+		{Op: op.MDCALL, Text: "MDOUT"},
+		{Op: op.LAL, Value: 1},
+		{Op: op.MDCALL, Text: "MDSELO"},
+		{Op: op.LCN, Value: 'b'},
+		{Op: op.MDCALL, Text: "MDOUT"},
+		{Op: op.MESS, Text: "hi$"},
+		{Op: op.LCN, Value: '$'},
+		{Op: op.MDCALL, Text: "MDERCH"},
+		{Op: op.LAL, Value: 7},
+		{Op: op.MDCALL, Text: "MDSELO"},
+		{Op: op.MDCALL, Text: "MDOUT"},
+		{Op: op.MDCALL, Text: "MDQUIT"},
+	} {
+		m.SetWord(pc, w)
+	}
+	if err := m.Run(nil, nil); err != nil {
+		t.Fatalf("run: want nil: got %v\n", err)
+	}
+	if got := stdout.String(); got != "a" {
+		t.Errorf("stdout: want %q: got %q\n", "a", got)
+	}
+	if got := listing.String(); got != "b$" {
+		t.Errorf("listing: want %q: got %q\n", "b$", got)
+	}
+	if want, got := "vm: starting 0\nhi\n\n", messages.String(); got != want {
+		t.Errorf("messages: want %q: got %q\n", want, got)
+	}
+	if m.Streams.Output != 1 {
+		t.Errorf("output: want %d: got %d\n", 1, m.Streams.Output)
+	}
+	if err := m.SelectOutput("stdout"); err != nil {
+		t.Errorf("select: want nil: got %v\n", err)
+	} else if m.Streams.Output != 0 {
+		t.Errorf("select: want %d: got %d\n", 0, m.Streams.Output)
+	}
+	if err := m.SelectOutput("printer"); !errors.Is(err, vm.ErrUnknownStream) {
+		t.Errorf("select: want unknown stream: got %v\n", err)
+	}
+}
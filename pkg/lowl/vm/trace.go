@@ -0,0 +1,186 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package vm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// TraceFormat is the format of the records written by the tracer.
+type TraceFormat int
+
+const (
+	TraceJSON TraceFormat = iota // one JSON object per line
+	TraceText                    // one compact, human-readable line
+)
+
+// ParseTraceFormat returns the format named "json" or "text".
+func ParseTraceFormat(name string) (TraceFormat, error) {
+	switch name {
+	case "json":
+		return TraceJSON, nil
+	case "text":
+		return TraceText, nil
+	}
+	return TraceJSON, fmt.Errorf("trace format %q: want json or text", name)
+}
+
+// TraceRecord describes the execution of a single step.
+type TraceRecord struct {
+	Step     int            `json:"step"`
+	PC       int            `json:"pc"`
+	Op       string         `json:"op"`
+	Value    int            `json:"value"`
+	ValueTwo int            `json:"value2"`
+	Text     string         `json:"text,omitempty"`
+	Line     int            `json:"line,omitempty"`
+	Source   string         `json:"source,omitempty"`
+	Before   TraceRegisters `json:"before"`
+	After    TraceRegisters `json:"after"`
+	Writes   []TraceWrite   `json:"writes,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// TraceRegisters are the registers before or after a step.
+type TraceRegisters struct {
+	A   int     `json:"a"`
+	B   int     `json:"b"`
+	C   int     `json:"c"`
+	Cmp CMPRSLT `json:"cmp"`
+	RS  int     `json:"rs"` // depth of the return stack
+}
+
+// TraceWrite records a value stored into memory.
+type TraceWrite struct {
+	Address int `json:"address"`
+	Value   int `json:"value"`
+}
+
+// tracer collects the record for the current step.
+type tracer struct {
+	w      io.Writer
+	format TraceFormat
+	steps  int
+	record TraceRecord
+}
+
+// SetTrace writes a trace record for every step to w. A nil w stops tracing.
+func (m *VM) SetTrace(w io.Writer, format TraceFormat) {
+	if w == nil {
+		m.tracer = nil
+		return
+	}
+	m.tracer = &tracer{w: w, format: format}
+}
+
+// registers returns the registers for a trace record.
+func (m *VM) registers() TraceRegisters {
+	return TraceRegisters{A: m.A, B: m.B, C: m.C, Cmp: m.Registers.Cmp, RS: len(m.RS)}
+}
+
+// before starts the record for the instruction at PC.
+func (t *tracer) before(m *VM) {
+	t.steps++
+	t.record = TraceRecord{Step: t.steps, PC: m.PC, Before: m.registers()}
+	if 0 <= m.PC && m.PC < len(m.Core) {
+		w := m.Core[m.PC]
+		t.record.Op, t.record.Value, t.record.ValueTwo, t.record.Text = w.Op.String(), w.Value, w.ValueTwo, w.Text
+		t.record.Line = w.Source.Line
+		if w.Source.Line != 0 {
+			t.record.Source = strings.TrimSpace(w.Source.Op.String() + " " + w.Source.Parameters)
+		}
+	}
+}
+
+// after completes the record and writes it.
+func (t *tracer) after(m *VM, err error) {
+	t.record.After = m.registers()
+	if err != nil {
+		t.record.Error = err.Error()
+	}
+	switch t.format {
+	case TraceText:
+		_, _ = fmt.Fprintln(t.w, t.record.String())
+	default:
+		data, _ := json.Marshal(t.record)
+		_, _ = fmt.Fprintf(t.w, "%s\n", data)
+	}
+}
+
+// noteWrite adds a memory write to the current record.
+func (m *VM) noteWrite(address, value int) {
+	if m.tracer != nil {
+		m.tracer.record.Writes = append(m.tracer.record.Writes, TraceWrite{Address: address, Value: value})
+	}
+}
+
+// String returns the record in the compact format.
+// Only the registers that changed are shown.
+func (r TraceRecord) String() string {
+	b := &strings.Builder{}
+	_, _ = fmt.Fprintf(b, "%6d %5d %-8s %6d %6d", r.Step, r.PC, r.Op, r.Value, r.ValueTwo)
+	if r.Text != "" {
+		_, _ = fmt.Fprintf(b, " %q", r.Text)
+	}
+	var changes []string
+	changed := func(name string, before, after int) {
+		if before != after {
+			changes = append(changes, fmt.Sprintf("%s %d->%d", name, before, after))
+		}
+	}
+	changed("A", r.Before.A, r.After.A)
+	changed("B", r.Before.B, r.After.B)
+	changed("C", r.Before.C, r.After.C)
+	if r.Before.Cmp != r.After.Cmp {
+		changes = append(changes, fmt.Sprintf("cmp %s", r.After.Cmp))
+	}
+	changed("RS", r.Before.RS, r.After.RS)
+	for _, w := range r.Writes {
+		changes = append(changes, fmt.Sprintf("[%d]=%d", w.Address, w.Value))
+	}
+	if len(changes) != 0 {
+		_, _ = fmt.Fprintf(b, " | %s", strings.Join(changes, " "))
+	}
+	if r.Line != 0 {
+		_, _ = fmt.Fprintf(b, " | line %d: %s", r.Line, r.Source)
+	}
+	if r.Error != "" {
+		_, _ = fmt.Fprintf(b, " | error: %s", r.Error)
+	}
+	return b.String()
+}
+
+// Diff returns the differences between two records of the same step.
+// The step number and the source text are not compared, so that traces
+// from different builds of the same program can be compared.
+func (r TraceRecord) Diff(o TraceRecord) []string {
+	var diffs []string
+	diff := func(name string, a, b any) {
+		if a != b {
+			diffs = append(diffs, fmt.Sprintf("%s: %v != %v", name, a, b))
+		}
+	}
+	diff("pc", r.PC, o.PC)
+	diff("op", r.Op, o.Op)
+	diff("value", r.Value, o.Value)
+	diff("value2", r.ValueTwo, o.ValueTwo)
+	diff("text", r.Text, o.Text)
+	for _, regs := range []struct {
+		name string
+		r, o TraceRegisters
+	}{{"before", r.Before, o.Before}, {"after", r.After, o.After}} {
+		diff(regs.name+".A", regs.r.A, regs.o.A)
+		diff(regs.name+".B", regs.r.B, regs.o.B)
+		diff(regs.name+".C", regs.r.C, regs.o.C)
+		diff(regs.name+".cmp", regs.r.Cmp, regs.o.Cmp)
+		diff(regs.name+".RS", regs.r.RS, regs.o.RS)
+	}
+	diff("writes", fmt.Sprintf("%v", r.Writes), fmt.Sprintf("%v", o.Writes))
+	diff("error", r.Error, o.Error)
+	return diffs
+}
@@ -0,0 +1,83 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package vm_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/maloquacious/ml_i/pkg/lowl/op"
+	"github.com/maloquacious/ml_i/pkg/lowl/vm"
+	"strings"
+	"testing"
+)
+
+// TestTrace tests the JSON Lines and text formats of the tracer.
+func TestTrace(t *testing.T) {
+	newvm := func() *vm.VM {
+		m := &vm.VM{}
+		m.SetWord(0, vm.Word{Op: op.LAL, Value: 3})
+		m.SetWord(1, vm.Word{Op: op.STV, Value: 9})
+		m.SetWord(2, vm.Word{Op: op.CAL, Value: 4})
+		m.SetWord(3, vm.Word{Op: op.MDCALL, Text: "MDQUIT"})
+		m.Core[1].Source.Line, m.Core[1].Source.Op, m.Core[1].Source.Parameters = 7, op.STV, "X,Y"
+		return m
+	}
+
+	m, b := newvm(), &bytes.Buffer{}
+	m.SetTrace(b, vm.TraceJSON)
+	if err := m.Run(nil, nil); err != nil {
+		t.Fatalf("run: want nil: got %v\n", err)
+	}
+	var records []vm.TraceRecord
+	for scanner := bufio.NewScanner(b); scanner.Scan(); {
+		var r vm.TraceRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("json: %v\n", err)
+		}
+		records = append(records, r)
+	}
+	if len(records) != 4 {
+		t.Fatalf("records: want 4: got %d\n", len(records))
+	}
+	if r := records[0]; r.Step != 1 || r.PC != 0 || r.Op != "LAL" || r.Before.A != 0 || r.After.A != 3 {
+		t.Errorf("records[0]: want 1 0 LAL 0->3: got %d %d %s %d->%d\n", r.Step, r.PC, r.Op, r.Before.A, r.After.A)
+	}
+	if r := records[1]; len(r.Writes) != 1 || r.Writes[0] != (vm.TraceWrite{Address: 9, Value: 3}) || r.Line != 7 || r.Source != "STV X,Y" {
+		t.Errorf("records[1]: want [9]=3 line 7: got %v line %d %q\n", r.Writes, r.Line, r.Source)
+	}
+	if r := records[2]; r.Before.Cmp != vm.IS_EQ || r.After.Cmp != vm.IS_LT {
+		t.Errorf("records[2]: want cmp == -> <<: got %s -> %s\n", r.Before.Cmp, r.After.Cmp)
+	}
+	if r := records[3]; r.Text != "MDQUIT" || r.Error != "quit" {
+		t.Errorf("records[3]: want MDQUIT quit: got %q %q\n", r.Text, r.Error)
+	}
+
+	m, b = newvm(), &bytes.Buffer{}
+	m.SetTrace(b, vm.TraceText)
+	if err := m.Run(nil, nil); err != nil {
+		t.Fatalf("run: want nil: got %v\n", err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("lines: want 4: got %d\n", len(lines))
+	}
+	for n, want := range []string{"A 0->3", "[9]=3 | line 7: STV X,Y", "cmp <<", "error: quit"} {
+		if !strings.Contains(lines[n], want) {
+			t.Errorf("lines[%d]: want %q: got %q\n", n, want, lines[n])
+		}
+	}
+
+	// records that differ only in step and source are the same
+	left, right := records[1], records[1]
+	right.Step, right.Line, right.Source = 99, 8, "STV Z"
+	if diffs := left.Diff(right); len(diffs) != 0 {
+		t.Errorf("diff: want none: got %v\n", diffs)
+	}
+	right.After.A, right.Writes = 4, nil
+	if diffs := left.Diff(right); len(diffs) != 2 {
+		t.Errorf("diff: want after and writes: got %v\n", diffs)
+	}
+}
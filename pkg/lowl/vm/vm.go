@@ -5,15 +5,20 @@
 package vm
 
 import (
+	"bufio"
 	"github.com/maloquacious/ml_i/pkg/lowl/op"
 	"io"
 )
 
 const (
 	MAX_WORDS = 65_536
-	MAX_STACK = 8_096
+	MAX_STACK = 8_096 // default size, in words, of the stack region
 )
 
+// EOF is the value loaded into register C by MDREAD at the end of input.
+// Once a stream has reached the end of input, every further read returns EOF.
+const EOF = -1
+
 type VM struct {
 	Name      string // name of the virtual machine
 	PC        int
@@ -28,22 +33,46 @@ type VM struct {
 		PARNM       int // points to the variable holding the subroutine parameter
 		SRCPT       int // points to the variable holding the source field pointer (stack moves)
 		Halted      bool
-		JumpValue   int // jump value for GOTBL
-		Start, Last int // starting, last address
+		Suspended   bool // run stopped by its budget or context; the next run resumes
+		JumpValue   int  // jump value for GOTBL
+		ERLSO       int  // address of the stack overflow handler; zero means none
+		WordSize    int  // bits in a machine word, 16 or 32; zero means unbounded
+		Start, Last int  // starting, last address
+		// StackStart and StackEnd bound the stack region reserved by the
+		// assembler, Core[StackStart:StackEnd]. The forwards stack grows up
+		// from StackStart and the backwards stack grows down from StackEnd.
+		StackStart, StackEnd int
 	}
 	Streams struct {
-		Stdout   io.Writer
-		Messages io.Writer
+		Stdin    *bufio.Reader // current input stream, read by MDREAD
+		Inputs   []*Input      // named input streams, selected by MDSELI
+		Input    int           // number of the current input stream
+		Stdout   io.Writer     // current output stream, written by MDOUT
+		Outputs  []*Output     // named output streams, selected by MDSELO
+		Output   int           // number of the current output stream
+		Messages io.Writer     // console stream, written by MESS and MDERCH
 	}
-	Core  [MAX_WORDS]Word
-	Stack [MAX_STACK]int
-	RS    []int // return stack for subroutine calls
+	Core [MAX_WORDS]Word
+	RS   []int // return stack for subroutine calls
+
+	// Symbols maps label and variable names to their addresses.
+	// It is set by the assembler and used by the debugging tools.
+	Symbols map[string]int
+
+	// Hooks, if not nil, are called as the machine runs.
+	Hooks *Hooks
+
+	written   int        // bytes written to the output streams by the current run
+	tracer    *tracer    // set by SetTrace
+	profile   *Profile   // set by SetProfile
+	coverage  *Coverage  // set by SetCoverage
+	recording *Recording // set by SetRecording
 }
 
 type Word struct {
 	Op       op.Code
 	Value    int
-	ValueTwo int // used by GOADD and GOBRPC
+	ValueTwo int // used by GOADD and GOBRPC, and by CAV and CAI for CompareAddresses
 	Text     string
 	Source   struct {
 		Line         int
@@ -0,0 +1,53 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package vm
+
+import "fmt"
+
+// SetWordSize sets the number of bits in a machine word to 16 or 32.
+// Zero, the default, means words are unbounded.
+//
+// With a bounded word size, every value stored in a register or in memory
+// wraps around as a signed, two's complement number of that size, and a
+// negative address is read as the unsigned number with the same bits.
+// Values already in memory, and literal operands, are not changed, but
+// they are wrapped when they are loaded or used, so that a register and
+// the word it was loaded from always compare equal.
+func (m *VM) SetWordSize(bits int) error {
+	switch bits {
+	case 0, 16, 32:
+	default:
+		return fmt.Errorf("%d bits: %w", bits, ErrWordSize)
+	}
+	m.Registers.WordSize = bits
+	m.wrapRegisters()
+	return nil
+}
+
+// FitsWord returns true if the value can be stored in a word of the given
+// number of bits, read either as a signed or as an unsigned number.
+// Every value fits in an unbounded word.
+func FitsWord(bits, value int) bool {
+	if bits == 0 {
+		return true
+	}
+	return -1<<(bits-1) <= value && value < 1<<bits
+}
+
+// wrap returns the value as it would be stored in a machine word.
+func (m *VM) wrap(value int) int {
+	switch m.Registers.WordSize {
+	case 16:
+		return int(int16(value))
+	case 32:
+		return int(int32(value))
+	}
+	return value
+}
+
+// wrapRegisters wraps registers A, B and C to the word size.
+func (m *VM) wrapRegisters() {
+	m.A, m.B, m.C = m.wrap(m.A), m.wrap(m.B), m.wrap(m.C)
+}
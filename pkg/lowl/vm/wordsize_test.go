@@ -0,0 +1,107 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package vm_test
+
+import (
+	"errors"
+	"github.com/maloquacious/ml_i/pkg/lowl/op"
+	"github.com/maloquacious/ml_i/pkg/lowl/vm"
+	"testing"
+)
+
+// TestWordSize tests that registers and memory wrap around at the word size.
+func TestWordSize(t *testing.T) {
+	for _, tc := range []struct {
+		bits    int
+		a       int // A after adding 1 to the largest positive 16-bit word
+		product int // A after multiplying it by 131072
+		bump    int // variable after bumping it by 1
+	}{
+		{bits: 0, a: 32768, product: 4294967296, bump: 32768},
+		{bits: 16, a: -32768, product: 0, bump: -32768},
+		{bits: 32, a: 32768, product: 0, bump: 32768},
+	} {
+		m := &vm.VM{}
+		if err := m.SetWordSize(tc.bits); err != nil {
+			t.Fatalf("%d: want nil: got %v\n", tc.bits, err)
+		}
+		m.A = 32767
+		m.SetWord(10, vm.Word{Op: op.CON, Value: 32767})
+		m.SetWord(0, vm.Word{Op: op.AAL, Value: 1})
+		m.SetWord(1, vm.Word{Op: op.MULTL, Value: 131072})
+		m.SetWord(2, vm.Word{Op: op.BUMP, Value: 10, ValueTwo: 1})
+		if err := m.Step(nil, nil); err != nil {
+			t.Fatalf("%d: AAL: want nil: got %v\n", tc.bits, err)
+		} else if m.A != tc.a {
+			t.Errorf("%d: AAL: want %d: got %d\n", tc.bits, tc.a, m.A)
+		}
+		if err := m.Step(nil, nil); err != nil {
+			t.Fatalf("%d: MULTL: want nil: got %v\n", tc.bits, err)
+		} else if m.A != tc.product {
+			t.Errorf("%d: MULTL: want %d: got %d\n", tc.bits, tc.product, m.A)
+		}
+		if err := m.Step(nil, nil); err != nil {
+			t.Fatalf("%d: BUMP: want nil: got %v\n", tc.bits, err)
+		} else if m.Core[10].Value != tc.bump {
+			t.Errorf("%d: BUMP: want %d: got %d\n", tc.bits, tc.bump, m.Core[10].Value)
+		}
+	}
+
+	// a negative 16-bit address is read as unsigned
+	m := &vm.VM{}
+	_ = m.SetWordSize(16)
+	m.SetWord(40000, vm.Word{Value: 7})
+	m.SetWord(0, vm.Word{Op: op.LAV, Value: 40000 - 65536})
+	if err := m.Step(nil, nil); err != nil {
+		t.Fatalf("address: want nil: got %v\n", err)
+	} else if m.A != 7 {
+		t.Errorf("address: want 7: got %d\n", m.A)
+	}
+
+	if err := m.Store(40001-65536, 9); err != nil {
+		t.Errorf("store: want nil: got %v\n", err)
+	} else if v, err := m.Load(40001 - 65536); err != nil || v != 9 {
+		t.Errorf("load: want 9: got %d %v\n", v, err)
+	}
+
+	// a register compares equal to the word it was loaded from,
+	// and to a literal with the same bits
+	m = &vm.VM{}
+	_ = m.SetWordSize(16)
+	m.SetWord(10, vm.Word{Op: op.CON, Value: 65535})
+	m.SetWord(0, vm.Word{Op: op.LAV, Value: 10})
+	m.SetWord(1, vm.Word{Op: op.CAV, Value: 10})
+	m.SetWord(2, vm.Word{Op: op.CAL, Value: 65535})
+	for pc, name := range []string{"LAV", "CAV", "CAL"} {
+		if err := m.Step(nil, nil); err != nil {
+			t.Fatalf("%s: want nil: got %v\n", name, err)
+		} else if pc != 0 && m.Registers.Cmp != vm.IS_EQ {
+			t.Errorf("%s: want %s: got %s\n", name, vm.IS_EQ, m.Registers.Cmp)
+		}
+	}
+	if m.A != -1 {
+		t.Errorf("LAV: want -1: got %d\n", m.A)
+	}
+
+	if err := m.SetWordSize(8); !errors.Is(err, vm.ErrWordSize) {
+		t.Errorf("8: want word size: got %v\n", err)
+	}
+	for _, tc := range []struct {
+		bits, value int
+		fits        bool
+	}{
+		{0, 1 << 40, true},
+		{16, -32768, true},
+		{16, 65535, true},
+		{16, -32769, false},
+		{16, 65536, false},
+		{32, -1 << 31, true},
+		{32, 1 << 32, false},
+	} {
+		if got := vm.FitsWord(tc.bits, tc.value); got != tc.fits {
+			t.Errorf("fits %d %d: want %v: got %v\n", tc.bits, tc.value, tc.fits, got)
+		}
+	}
+}
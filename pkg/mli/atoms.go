@@ -0,0 +1,68 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package mli
+
+import (
+	"strings"
+	"unicode"
+)
+
+// atom is the unit of text that ML/I matches names against.
+// An atom is either an identifier (a sequence of letters and digits)
+// or a single character that is not a letter or digit.
+type atom struct {
+	text string
+	line int
+}
+
+// atomize splits the text into atoms. line is the line number of the first atom.
+func atomize(text string, line int) []atom {
+	var atoms []atom
+	runes := []rune(text)
+	for pos := 0; pos < len(runes); {
+		start := pos
+		if isalnum(runes[pos]) {
+			for pos < len(runes) && isalnum(runes[pos]) {
+				pos++
+			}
+		} else {
+			pos++
+		}
+		atoms = append(atoms, atom{text: string(runes[start:pos]), line: line})
+		if runes[start] == '\n' {
+			line++
+		}
+	}
+	return atoms
+}
+
+// isspace returns true if the atom is a space, tab, or new-line.
+func (a atom) isspace() bool {
+	return a.text == " " || a.text == "\t" || a.text == "\n" || a.text == "\r"
+}
+
+func isalnum(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// join returns the text of the atoms.
+func join(atoms []atom) string {
+	sb := strings.Builder{}
+	for _, a := range atoms {
+		sb.WriteString(a.text)
+	}
+	return sb.String()
+}
+
+// trim returns the atoms without leading and trailing spaces.
+func trim(atoms []atom) []atom {
+	for len(atoms) != 0 && atoms[0].isspace() {
+		atoms = atoms[1:]
+	}
+	for len(atoms) != 0 && atoms[len(atoms)-1].isspace() {
+		atoms = atoms[:len(atoms)-1]
+	}
+	return atoms
+}
@@ -0,0 +1,45 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package mli
+
+import "fmt"
+
+var (
+	ErrArgumentOutOfRange  = fmt.Errorf("argument out of range")
+	ErrBadCondition        = fmt.Errorf("invalid condition")
+	ErrBadExpression       = fmt.Errorf("invalid expression")
+	ErrBadStructure        = fmt.Errorf("invalid structure")
+	ErrDelimiterOutOfRange = fmt.Errorf("delimiter out of range")
+	ErrMCGOOutsideMacro    = fmt.Errorf("MCGO outside macro")
+	ErrMissingMacro        = fmt.Errorf("warning marker not followed by a macro name")
+	ErrTooDeep             = fmt.Errorf("macro calls nested too deeply")
+	ErrTooManyJumps        = fmt.Errorf("too many MCGO jumps")
+	ErrUndefinedLabel      = fmt.Errorf("undefined label")
+	ErrUndefinedVariable   = fmt.Errorf("undefined variable")
+	ErrUnmatchedDelimiter  = fmt.Errorf("closing delimiter not found")
+	ErrUnsupported         = fmt.Errorf("not supported")
+)
+
+// Error is returned by Process when the source text can not be processed.
+// It carries the line where the error was detected and the name of the
+// construction (macro, skip or insert) being processed, if any.
+type Error struct {
+	Line  int    // line of the text where the error was detected
+	Macro string // name of the construction being processed, if any
+	Err   error  // the underlying error
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	if e.Macro == "" {
+		return fmt.Sprintf("mli: %d: %v", e.Line, e.Err)
+	}
+	return fmt.Sprintf("mli: %d: %s: %v", e.Line, e.Macro, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,425 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package mli
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// frame is the environment of a single macro call.
+type frame struct {
+	parent *frame // frame of the caller; nil at the top level
+	macro  *construction
+	line   int        // line of the call
+	args   []argument // arguments of the call
+	delims []string   // text of the name (D0) and the delimiters found
+	temps  map[int]int
+	locals []*construction // constructions defined in this call
+	depth  int
+}
+
+// argument is the unevaluated text of an argument and the frame it belongs to.
+type argument struct {
+	text  []atom
+	frame *frame
+}
+
+// jump is returned by MCGO to transfer control within a replacement text.
+type jump struct {
+	frame *frame
+	label int
+}
+
+func (j *jump) Error() string {
+	return fmt.Sprintf("MCGO L%d", j.label)
+}
+
+func newMacro(structure, replacement string, line int) (*construction, error) {
+	name, delims, err := parseStructure(structure)
+	if err != nil {
+		return nil, err
+	}
+	return &construction{kind: macroKind, name: name, delims: delims, replacement: atomize(replacement, line)}, nil
+}
+
+func newInsert(structure string) (*construction, error) {
+	name, delims, err := parseStructure(structure)
+	if err != nil {
+		return nil, err
+	} else if len(delims.delims) == 0 {
+		return nil, fmt.Errorf("%q: insert wants name and closing delimiter: %w", structure, ErrBadStructure)
+	}
+	for _, d := range delims.delims {
+		if !d.closing() {
+			return nil, fmt.Errorf("%q: insert wants name and closing delimiter: %w", structure, ErrBadStructure)
+		}
+	}
+	return &construction{kind: insertKind, name: name, delims: delims}, nil
+}
+
+func newWarning(structure string) (*construction, error) {
+	name, delims, err := parseStructure(structure)
+	if err != nil {
+		return nil, err
+	} else if len(delims.delims) != 0 {
+		return nil, fmt.Errorf("%q: warning marker wants name only: %w", structure, ErrBadStructure)
+	}
+	return &construction{kind: warnKind, name: name}, nil
+}
+
+func newSkip(options, structure string) (*construction, error) {
+	name, delims, err := parseStructure(structure)
+	if err != nil {
+		return nil, err
+	} else if len(delims.delims) == 0 {
+		return nil, fmt.Errorf("%q: skip wants closing delimiter: %w", structure, ErrBadStructure)
+	}
+	c := &construction{kind: skipKind, name: name, delims: delims}
+	for _, ch := range strings.ToUpper(options) {
+		switch ch {
+		case 'D':
+			c.delim = true
+		case 'M':
+			c.matched = true
+		case 'T':
+			c.text = true
+		case ' ':
+		default:
+			return nil, fmt.Errorf("%q: option %q: %w", options, ch, ErrBadStructure)
+		}
+	}
+	return c, nil
+}
+
+// find returns the construction whose name starts at pos, searching the
+// call frames from innermost to outermost and then the global environment.
+// The most recently defined construction wins, unless it has been hidden
+// by MCNODEF, MCNOINS, MCNOSKIP, or MCNOWARN.
+func (e *Engine) find(text []atom, pos int, f *frame) *construction {
+	var hidden []*construction
+	visible := func(c *construction) bool {
+		if !matches(text, pos, c.name) {
+			return false
+		}
+		for _, h := range hidden {
+			if h.kind == c.kind && h.String() == c.String() {
+				return false
+			}
+		}
+		if c.hidden {
+			hidden = append(hidden, c)
+			return false
+		}
+		return true
+	}
+	for ; f != nil; f = f.parent {
+		for i := len(f.locals) - 1; i >= 0; i-- {
+			if c := f.locals[i]; visible(c) {
+				return c
+			}
+		}
+	}
+	for i := len(e.global) - 1; i >= 0; i-- {
+		if c := e.global[i]; visible(c) {
+			return c
+		}
+	}
+	return nil
+}
+
+// recognize returns the construction that starts at pos and the position
+// of its name, or nil if there is none. Inserts are only recognized inside
+// macro calls. While a warning marker is defined, macros are only recognized
+// when they follow a warning marker. The marker and any spaces or tabs after
+// it are dropped, and the position returned is that of the macro name.
+func (e *Engine) recognize(text []atom, pos int, f *frame) (*construction, int, error) {
+	c := e.find(text, pos, f)
+	switch {
+	case c == nil:
+		return nil, pos, nil
+	case c.kind == insertKind && f == nil:
+		return nil, pos, nil
+	case c.kind == macroKind && e.warning(f):
+		return nil, pos, nil
+	case c.kind != warnKind:
+		return c, pos, nil
+	}
+	p := pos + len(c.name)
+	for p < len(text) && (text[p].text == " " || text[p].text == "\t") {
+		p++
+	}
+	if m := e.find(text, p, f); m != nil && m.kind == macroKind {
+		return m, p, nil
+	}
+	return nil, pos, &Error{Line: text[pos].line, Macro: c.String(), Err: ErrMissingMacro}
+}
+
+// warning returns true if a warning marker is visible from frame f.
+func (e *Engine) warning(f *frame) bool {
+	hidden := map[string]bool{}
+	visible := func(c *construction) bool {
+		if c.kind != warnKind || hidden[c.String()] {
+			return false
+		} else if c.hidden {
+			hidden[c.String()] = true
+			return false
+		}
+		return true
+	}
+	for ; f != nil; f = f.parent {
+		for i := len(f.locals) - 1; i >= 0; i-- {
+			if visible(f.locals[i]) {
+				return true
+			}
+		}
+	}
+	for i := len(e.global) - 1; i >= 0; i-- {
+		if visible(e.global[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluate scans the text in the environment of frame f and writes the result.
+func (e *Engine) evaluate(text []atom, f *frame, out *strings.Builder) error {
+	for pos := 0; pos < len(text); {
+		c, start, err := e.recognize(text, pos, f)
+		if err != nil {
+			return err
+		} else if c == nil {
+			out.WriteString(text[pos].text)
+			pos++
+			continue
+		}
+		pos = start
+		switch c.kind {
+		case skipKind:
+			end, closing, err := e.skipEnd(c, text, pos)
+			if err != nil {
+				return err
+			}
+			if c.delim {
+				out.WriteString(strings.Join(c.name, ""))
+			}
+			if c.text {
+				out.WriteString(join(text[pos+len(c.name) : end-closing]))
+			}
+			if c.delim {
+				out.WriteString(join(text[end-closing : end]))
+			}
+			pos = end
+		case insertKind:
+			args, _, end, err := e.collect(c, text, pos, f)
+			if err != nil {
+				return err
+			}
+			if err := e.insert(c, args[0].text, f, out); err != nil {
+				return err
+			}
+			pos = end
+		case macroKind:
+			args, delims, end, err := e.collect(c, text, pos, f)
+			if err != nil {
+				return err
+			}
+			if err := e.call(c, text[pos].line, args, delims, f, out); err != nil {
+				return err
+			}
+			pos = end
+		}
+	}
+	return nil
+}
+
+// skipEnd returns the position following the closing delimiter of the
+// skip starting at pos and the number of atoms in the closing delimiter.
+func (e *Engine) skipEnd(c *construction, text []atom, pos int) (int, int, error) {
+	p := pos + len(c.name)
+	for n := c.delims; ; {
+		d := n.match(text, p)
+		for d == nil {
+			if p >= len(text) {
+				return 0, 0, &Error{Line: text[pos].line, Macro: c.String(), Err: fmt.Errorf("%s: %w", n, ErrUnmatchedDelimiter)}
+			} else if c.matched && matches(text, p, c.name) {
+				var err error
+				if p, _, err = e.skipEnd(c, text, p); err != nil {
+					return 0, 0, err
+				}
+			} else {
+				p++
+			}
+			d = n.match(text, p)
+		}
+		p += len(d.atoms)
+		if d.closing() {
+			return p, len(d.atoms), nil
+		}
+		n = d.next
+	}
+}
+
+// collect finds the delimiters of the construction starting at pos.
+// It returns the arguments, the text of the name and delimiters, and
+// the position following the closing delimiter. Where the structure
+// gives a choice, the first delimiter in the structure that matches is
+// taken. Constructions nested in the arguments are recognized so that
+// their delimiters are not mistaken for ours.
+func (e *Engine) collect(c *construction, text []atom, pos int, f *frame) ([]argument, []string, int, error) {
+	delims := []string{join(text[pos : pos+len(c.name)])}
+	var args []argument
+	p := pos + len(c.name)
+	for n := c.delims; len(n.delims) != 0; {
+		start := p
+		var delim *delimiter
+		for {
+			if p >= len(text) {
+				return nil, nil, 0, &Error{Line: text[pos].line, Macro: c.String(), Err: fmt.Errorf("%s: %w", n, ErrUnmatchedDelimiter)}
+			} else if delim = n.match(text, p); delim != nil {
+				break
+			}
+			nested, start, err := e.recognize(text, p, f)
+			if err != nil {
+				return nil, nil, 0, err
+			} else if nested == nil {
+				p++
+				continue
+			}
+			if p = start; nested.kind == skipKind {
+				p, _, err = e.skipEnd(nested, text, p)
+			} else {
+				_, _, p, err = e.collect(nested, text, p, f)
+			}
+			if err != nil {
+				return nil, nil, 0, err
+			}
+		}
+		args = append(args, argument{text: text[start:p], frame: f})
+		delims = append(delims, join(text[p:p+len(delim.atoms)]))
+		p += len(delim.atoms)
+		n = delim.next
+	}
+	return args, delims, p, nil
+}
+
+// call evaluates the replacement text of a macro.
+func (e *Engine) call(c *construction, line int, args []argument, delims []string, f *frame, out *strings.Builder) error {
+	depth := 1
+	if f != nil {
+		depth = f.depth + 1
+	}
+	if depth > e.MaxDepth {
+		return &Error{Line: line, Macro: c.String(), Err: fmt.Errorf("depth %d: %w", depth, ErrTooDeep)}
+	}
+	if c.operation != nil {
+		return c.operation(e, &call{macro: c, line: line, args: args, frame: f, out: out})
+	}
+
+	e.serial++
+	callee := &frame{
+		parent: f,
+		macro:  c,
+		line:   line,
+		args:   args,
+		delims: delims,
+		temps:  map[int]int{1: len(args), 2: e.serial, 3: depth},
+		depth:  depth,
+	}
+
+	start, jumps := 0, 0
+	for {
+		err := e.evaluate(c.replacement[start:], callee, out)
+		var j *jump
+		if err == nil || !errors.As(err, &j) || j.frame != callee {
+			return err
+		} else if j.label == 0 {
+			return nil
+		} else if jumps++; jumps > e.MaxJumps {
+			return &Error{Line: line, Macro: c.String(), Err: fmt.Errorf("more than %d: %w", e.MaxJumps, ErrTooManyJumps)}
+		}
+		var ok bool
+		if start, ok = e.findLabel(c.replacement, j.label, callee); !ok {
+			return &Error{Line: line, Macro: c.String(), Err: fmt.Errorf("L%d: %w", j.label, ErrUndefinedLabel)}
+		}
+	}
+}
+
+// findLabel returns the position following the insert that defines the label.
+func (e *Engine) findLabel(text []atom, label int, f *frame) (int, bool) {
+	want := fmt.Sprintf("L%d", label)
+	for pos := 0; pos < len(text); {
+		c := e.find(text, pos, f)
+		if c == nil || c.kind == macroKind || c.kind == warnKind {
+			pos++
+			continue
+		}
+		if c.kind == skipKind {
+			end, _, err := e.skipEnd(c, text, pos)
+			if err != nil {
+				return 0, false
+			}
+			pos = end
+			continue
+		}
+		args, _, end, err := e.collect(c, text, pos, f)
+		if err != nil {
+			return 0, false
+		} else if strings.TrimSpace(join(args[0].text)) == want {
+			return end, true
+		}
+		pos = end
+	}
+	return 0, false
+}
+
+// insert evaluates the text of an insert and writes the value.
+func (e *Engine) insert(c *construction, text []atom, f *frame, out *strings.Builder) error {
+	line := f.line
+	if len(text) != 0 {
+		line = text[0].line
+	}
+	sb := &strings.Builder{}
+	if err := e.evaluate(text, f, sb); err != nil {
+		return err
+	}
+	value := strings.TrimSpace(sb.String())
+
+	// index returns the number following the prefix
+	index := func(prefix string) (int, bool) {
+		if !strings.HasPrefix(value, prefix) {
+			return 0, false
+		}
+		n, err := strconv.Atoi(value[len(prefix):])
+		return n, err == nil
+	}
+
+	if n, ok := index("WA"); ok {
+		if n < 1 || n > len(f.args) {
+			return &Error{Line: line, Macro: f.macro.String(), Err: fmt.Errorf("%s: %w", value, ErrArgumentOutOfRange)}
+		}
+		out.WriteString(join(f.args[n-1].text))
+	} else if n, ok = index("A"); ok {
+		if n < 1 || n > len(f.args) {
+			return &Error{Line: line, Macro: f.macro.String(), Err: fmt.Errorf("%s: %w", value, ErrArgumentOutOfRange)}
+		}
+		arg := f.args[n-1]
+		return e.evaluate(arg.text, arg.frame, out)
+	} else if n, ok = index("D"); ok {
+		if n < 0 || n >= len(f.delims) {
+			return &Error{Line: line, Macro: f.macro.String(), Err: fmt.Errorf("%s: %w", value, ErrDelimiterOutOfRange)}
+		}
+		out.WriteString(f.delims[n])
+	} else if _, ok = index("L"); ok {
+		// labels are only used by MCGO
+	} else if n, err := e.expression(value, f); err != nil {
+		return &Error{Line: line, Macro: c.String(), Err: fmt.Errorf("%q: %w", value, err)}
+	} else {
+		out.WriteString(strconv.Itoa(n))
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package mli
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// expression evaluates a macro-time expression from left to right.
+// Operands are integers or the variables Tn and Pn; operators are + - * /.
+// A leading minus sign negates the first operand.
+func (e *Engine) expression(text string, f *frame) (int, error) {
+	var items []string
+	for _, a := range atomize(text, 1) {
+		if !a.isspace() {
+			items = append(items, a.text)
+		}
+	}
+	if len(items) == 0 {
+		return 0, fmt.Errorf("empty: %w", ErrBadExpression)
+	}
+
+	operand := func(i int) (int, int, error) {
+		negate := false
+		if i < len(items) && (items[i] == "-" || items[i] == "+") {
+			negate, i = items[i] == "-", i+1
+		}
+		if i >= len(items) {
+			return 0, i, fmt.Errorf("missing operand: %w", ErrBadExpression)
+		}
+		n, err := strconv.Atoi(items[i])
+		if err != nil {
+			if n, err = e.variable(items[i], f); err != nil {
+				return 0, i, err
+			}
+		}
+		if negate {
+			n = -n
+		}
+		return n, i + 1, nil
+	}
+
+	value, i, err := operand(0)
+	if err != nil {
+		return 0, err
+	}
+	for i < len(items) {
+		operator := items[i]
+		var n int
+		if n, i, err = operand(i + 1); err != nil {
+			return 0, err
+		}
+		switch operator {
+		case "+":
+			value = value + n
+		case "-":
+			value = value - n
+		case "*":
+			value = value * n
+		case "/":
+			if n == 0 {
+				return 0, fmt.Errorf("division by zero: %w", ErrBadExpression)
+			}
+			value = value / n
+		default:
+			return 0, fmt.Errorf("operator %q: %w", operator, ErrBadExpression)
+		}
+	}
+	return value, nil
+}
+
+// parseVariable splits a variable name into its kind (T or P) and index.
+func parseVariable(name string) (byte, int, error) {
+	if len(name) < 2 || (name[0] != 'T' && name[0] != 'P') {
+		return 0, 0, fmt.Errorf("%q: %w", name, ErrUndefinedVariable)
+	}
+	n, err := strconv.Atoi(name[1:])
+	if err != nil || n < 1 {
+		return 0, 0, fmt.Errorf("%q: %w", name, ErrUndefinedVariable)
+	}
+	return name[0], n, nil
+}
+
+// variable returns the value of a macro-time variable.
+// Temporary variables belong to the current macro call.
+func (e *Engine) variable(name string, f *frame) (int, error) {
+	kind, n, err := parseVariable(name)
+	if err != nil {
+		return 0, err
+	}
+	switch kind {
+	case 'P':
+		if n > len(e.perm) {
+			return 0, fmt.Errorf("%q: %w", name, ErrUndefinedVariable)
+		}
+		return e.perm[n-1], nil
+	default:
+		if f == nil {
+			return 0, fmt.Errorf("%q: outside macro: %w", name, ErrUndefinedVariable)
+		}
+		return f.temps[n], nil
+	}
+}
+
+// setVariable updates the value of a macro-time variable.
+func (e *Engine) setVariable(name string, value int, f *frame) error {
+	kind, n, err := parseVariable(name)
+	if err != nil {
+		return err
+	}
+	switch kind {
+	case 'P':
+		if n > len(e.perm) {
+			return fmt.Errorf("%q: %w", name, ErrUndefinedVariable)
+		}
+		e.perm[n-1] = value
+	default:
+		if f == nil {
+			return fmt.Errorf("%q: outside macro: %w", name, ErrUndefinedVariable)
+		}
+		f.temps[n] = value
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+// Package mli implements the ML/I macro processor in Go.
+//
+// It does not depend on the LOWL virtual machine. Source text is split into
+// atoms (identifiers and single punctuation characters) and scanned for
+// constructions: macros, inserts, and skips. Each construction has a name
+// and a delimiter structure, e.g. "ADD TO NL" is the macro ADD with the
+// delimiters TO and new-line. OPT, OR, and ALL give a choice of delimiters,
+// and nodes (N1, N2, ...) let a structure repeat, e.g. "SUM N1 OPT + N1 OR
+// NL ALL" matches any number of arguments separated by + and ending with
+// a new-line.
+//
+// The operation macros are
+//
+//	MCDEF structure AS replacement NL     define a macro
+//	MCDEFG structure AS replacement NL    define a global macro
+//	MCSKIP [options,] structure NL        define a skip (options M, T, D)
+//	MCSKIPG [options,] structure NL       define a global skip
+//	MCINS structure NL                    define an insert
+//	MCINSG structure NL                   define a global insert
+//	MCWARN name NL                        define a warning marker
+//	MCWARNG name NL                       define a global warning marker
+//	MCSET variable = expression NL        set a macro-time variable
+//	MCGO label [IF|UNLESS condition] NL   macro-time go to
+//	MCPVAR count NL                       set the number of permanent variables
+//	MCNOTE text NL                        write text to the message stream
+//	MCNODEF name NL                       hide a macro
+//	MCNOINS name NL                       hide an insert
+//	MCNOSKIP name NL                      hide a skip
+//	MCNOWARN name NL                      hide a warning marker
+//	MCLENG(text)                          the number of characters in text
+//	MCSUB(text, n, m)                     characters n through m of text
+//
+// Constructions defined or hidden inside a macro call are local to the
+// call, except those defined by MCDEFG, MCINSG, MCSKIPG and MCWARNG.
+//
+// While a warning marker is defined, macros (including the operation
+// macros) are only recognized when they follow a warning marker, e.g.
+// "$MCDEF" after "MCWARN $". The marker and any spaces or tabs after it are
+// removed from the text. A marker that is not followed by a macro name
+// is an error.
+//
+// MCALTER is not supported and returns ErrUnsupported.
+//
+// Inside replacement text an insert (e.g. "%A1.") can insert an argument
+// (An), a written argument (WAn), a delimiter (Dn), or the value of an
+// expression of macro-time variables (Tn, Pn) and integers. "%Ln." places
+// label n for MCGO; MCGO L0 exits the macro. Expressions are evaluated
+// left to right. Conditions compare strings with = or integers with EN,
+// NE, GR, GE, LT or LE.
+//
+// When a macro is called, T1 is the number of arguments, T2 is a serial
+// number unique to the call, and T3 is the depth of nesting.
+package mli
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// Engine is an ML/I macro processor.
+type Engine struct {
+	Messages io.Writer // MCNOTE text is written here; ignored if nil
+	MaxDepth int       // maximum depth of nested macro calls
+	MaxJumps int       // maximum number of MCGO jumps in a single call
+
+	global []*construction // global environment
+	perm   []int           // permanent variables
+	serial int             // number of macro calls so far
+}
+
+// New returns an engine with the operation macros defined.
+func New() *Engine {
+	e := &Engine{
+		MaxDepth: 250,
+		MaxJumps: 100_000,
+		perm:     make([]int, 10),
+	}
+	e.defineOperations()
+	return e
+}
+
+// Define adds a global macro with the given structure and replacement text.
+func (e *Engine) Define(structure, replacement string) error {
+	c, err := newMacro(structure, replacement, 1)
+	if err != nil {
+		return err
+	}
+	e.global = append(e.global, c)
+	return nil
+}
+
+// DefineInsert adds a global insert with the given structure (e.g. "% .").
+func (e *Engine) DefineInsert(structure string) error {
+	c, err := newInsert(structure)
+	if err != nil {
+		return err
+	}
+	e.global = append(e.global, c)
+	return nil
+}
+
+// DefineSkip adds a global skip with the given options and structure.
+// Options are any combination of M (matched), T (text) and D (delimiters).
+func (e *Engine) DefineSkip(options, structure string) error {
+	c, err := newSkip(options, structure)
+	if err != nil {
+		return err
+	}
+	e.global = append(e.global, c)
+	return nil
+}
+
+// Process reads the source text, evaluates it, and writes the result.
+// Errors are returned as *Error.
+func (e *Engine) Process(r io.Reader, w io.Writer) error {
+	input, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	out := &strings.Builder{}
+	err = e.evaluate(atomize(string(input), 1), nil, out)
+	bw := bufio.NewWriter(w)
+	if _, werr := bw.WriteString(out.String()); werr != nil && err == nil {
+		err = werr
+	}
+	if ferr := bw.Flush(); ferr != nil && err == nil {
+		err = ferr
+	}
+	return err
+}
@@ -0,0 +1,127 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package mli_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/maloquacious/ml_i/pkg/mli"
+	"strings"
+	"testing"
+)
+
+func TestProcess(t *testing.T) {
+	const prelude = "MCSKIP MT,<>\nMCINS %.\n"
+	for _, tc := range []struct {
+		id          int
+		input, want string
+	}{
+		{id: 1, input: "no macros here\n", want: "no macros here\n"},
+		{id: 2, input: prelude + "MCDEF HELLO AS <world>\nHELLO HELLO\n", want: "world world\n"},
+		{id: 3, input: prelude + "MCDEF ADD TO NL AS <%A2.+%A1.;\n>\nADD 1 TO 2\n", want: " 2+ 1 ;\n"},
+		{id: 4, input: prelude + "MCDEF CNT NL AS <%T1.>\nCNT\n", want: "1"},
+		{id: 5, input: prelude + "MCSKIP // NL\nkeep // drop this\nkeep\n", want: "keep keep\n"},
+		{id: 6, input: prelude + "MCSKIP DT,[]\n[a]\n", want: "[a]\n"},
+		{id: 7, input: prelude + "<MCDEF>\n", want: "MCDEF\n"},
+		{id: 8, input: prelude + "MCDEF INC AS <MCSET P1 = P1 + 1\n%P1.>\nINC INC INC\n", want: "1 2 3\n"},
+		{id: 9, input: prelude + "MCDEF REP NL AS <MCSET T2 = 0\n%L1.MCSET T2 = T2 + 1\nx%T2.MCGO L1 IF T2 LT %A1.\n>\nREP 3\n", want: "x1x2x3"},
+		{id: 10, input: prelude + "MCDEF EQ AND NL AS <MCGO L1 UNLESS %A1. = %A2.\nsame<>MCGO L0\n%L1.diff>\nEQ a AND a\nEQ a AND b\n", want: "samediff"},
+		{id: 11, input: prelude + "MCDEF OUTER AS <MCDEF INNER AS <in>\nINNER>\nOUTER INNER\n", want: "in INNER\n"},
+		{id: 12, input: prelude + "MCDEF D NL AS <%D0.%D1.>\nD\n", want: "D\n"},
+		{id: 13, input: prelude + "MCDEF W NL AS <%WA1.>\nMCDEF X AS <y>\nW X\n", want: " X"},
+		{id: 14, input: prelude + "MCDEF SQ NL AS <%A1.*%A1.=%%A1.*%A1..>\nSQ 7\n", want: " 7* 7=49"},
+		{id: 15, input: prelude + "MCDEF SUM N1 OPT + N1 OR NL ALL AS <%T1.>\nSUM a+b+c\nSUM a\n", want: "31"},
+		{id: 16, input: prelude + "MCDEF IF THEN OPT ELSE END OR END ALL AS <[%A2.|%D2.]>\nIF a THEN b END\nIF a THEN b ELSE c END\n", want: "[ b |END]\n[ b |ELSE]\n"},
+		{id: 17, input: prelude + "MCSKIP ( OPT ) OR ] ALL\na(b]c(d)e\n", want: "ace\n"},
+		{id: 18, input: prelude + "MCDEF X AS <y>\nX MCNODEF <X>\nX\n", want: "y X\n"},
+		{id: 19, input: prelude + "MCDEF X AS <y>\nMCDEF H AS <MCNODEF <X>\nX>\nH X\n", want: "X y\n"},
+		{id: 20, input: prelude + "MCNOINS %\nMCDEF Q AS <%T1.>\nQ\n", want: "%T1.\n"},
+		{id: 21, input: prelude + "MCSKIP / WITH / NL\nMCNOSKIP </ WITH />\na // b\n", want: "a // b\n"},
+		{id: 22, input: prelude + "MCLENG(hello world) MCLENG()\n", want: "11 0\n"},
+		{id: 23, input: prelude + "MCSUB(hello, 2, 4)MCSUB(hello, 3, 2)MCSUB(hello, 1, 5)\n", want: "ellhello\n"},
+		{id: 24, input: prelude + "MCDEF G AS <MCINS ! .\n>\nG\nMCDEF Q AS <!T1.>\nQ\n", want: "\n!T1.\n"},
+		{id: 25, input: prelude + "MCDEF G AS <MCINSG ! .\n>\nG\nMCDEF Q AS <!T1.>\nQ\n", want: "\n0\n"},
+		{id: 26, input: prelude + "MCDEF G AS <MCSKIPG // NL\n>\nG\na // b\nc\n", want: "\na c\n"},
+		{id: 27, input: prelude + "MCDEF X AS <y>\nMCWARN $\nX $X $ X\n$MCNOWARN <$>\nX\n", want: "X y y\ny\n"},
+		{id: 28, input: prelude + "MCDEF X AS <y>\nMCDEF W AS <MCWARN $\nX>\nW X\n", want: "X y\n"},
+	} {
+		e := mli.New()
+		out := &bytes.Buffer{}
+		if err := e.Process(strings.NewReader(tc.input), out); err != nil {
+			t.Errorf("%d: want nil: got %v\n", tc.id, err)
+			continue
+		}
+		if got := out.String(); got != tc.want {
+			t.Errorf("%d: want %q: got %q\n", tc.id, tc.want, got)
+		}
+	}
+}
+
+func TestDefine(t *testing.T) {
+	e := mli.New()
+	if err := e.DefineSkip("MT", "<>"); err != nil {
+		t.Fatalf("skip: want nil: got %v\n", err)
+	} else if err = e.DefineInsert("% ."); err != nil {
+		t.Fatalf("insert: want nil: got %v\n", err)
+	} else if err = e.Define("WITH SWAP NL", "%A1."); err == nil {
+		t.Fatalf("define: want error: got nil\n")
+	} else if err = e.Define("SWAP , NL", "%A2.,%A1."); err != nil {
+		t.Fatalf("define: want nil: got %v\n", err)
+	}
+	for _, structure := range []string{
+		"OPT A OR B ALL",
+		"A OPT B",
+		"A OR B",
+		"A OPT B OR ALL",
+		"A OPT N1 OR B ALL",
+		"A N1 B N1 C",
+		"A N1 N1",
+	} {
+		if err := e.Define(structure, ""); !errors.Is(err, mli.ErrBadStructure) {
+			t.Errorf("%q: want %v: got %v\n", structure, mli.ErrBadStructure, err)
+		}
+	}
+	out := &bytes.Buffer{}
+	if err := e.Process(strings.NewReader("SWAP a,b\n"), out); err != nil {
+		t.Fatalf("process: want nil: got %v\n", err)
+	} else if got, want := out.String(), "b, a"; got != want {
+		t.Errorf("process: want %q: got %q\n", want, got)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	const prelude = "MCSKIP MT,<>\nMCINS %.\n"
+	for _, tc := range []struct {
+		id    int
+		input string
+		line  int
+		want  error
+	}{
+		{id: 1, input: prelude + "MCDEF ADD TO NL AS <x>\n\nADD 1 TO 2", line: 5, want: mli.ErrUnmatchedDelimiter},
+		{id: 2, input: prelude + "MCDEF BAD NL AS <%A2.>\nBAD 1\n", line: 3, want: mli.ErrArgumentOutOfRange},
+		{id: 3, input: prelude + "MCDEF BAD AS <MCGO L9\n>\nBAD\n", line: 5, want: mli.ErrUndefinedLabel},
+		{id: 4, input: prelude + "MCGO L1\n", line: 3, want: mli.ErrMCGOOutsideMacro},
+		{id: 5, input: prelude + "MCDEF LOOP AS <LOOP>\nLOOP\n", line: 3, want: mli.ErrTooDeep},
+		{id: 6, input: prelude + "MCSET P1 = 1 / 0\n", line: 3, want: mli.ErrBadExpression},
+		{id: 7, input: prelude + "MCDEF SUM N1 OPT + N1 OR NL ALL AS <x>\nSUM a + b", line: 4, want: mli.ErrUnmatchedDelimiter},
+		{id: 8, input: prelude + "MCSUB(hello, 0, 2)\n", line: 3, want: mli.ErrArgumentOutOfRange},
+		{id: 9, input: prelude + "MCALTER x\n", line: 3, want: mli.ErrUnsupported},
+		{id: 10, input: prelude + "MCWARN $\n$ q\n", line: 4, want: mli.ErrMissingMacro},
+	} {
+		e := mli.New()
+		err := e.Process(strings.NewReader(tc.input), &bytes.Buffer{})
+		var me *mli.Error
+		if !errors.As(err, &me) {
+			t.Errorf("%d: want *mli.Error: got %v\n", tc.id, err)
+			continue
+		}
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%d: want %v: got %v\n", tc.id, tc.want, err)
+		}
+		if me.Line != tc.line {
+			t.Errorf("%d: line: want %d: got %d\n", tc.id, tc.line, me.Line)
+		}
+	}
+}
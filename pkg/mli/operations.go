@@ -0,0 +1,346 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package mli
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// operation implements an operation macro.
+type operation func(e *Engine, c *call) error
+
+// call holds the arguments passed to an operation macro.
+type call struct {
+	macro *construction
+	line  int
+	args  []argument
+	frame *frame // frame of the caller
+	out   *strings.Builder
+}
+
+// error wraps err with the location of the call.
+func (c *call) error(err error) error {
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	return &Error{Line: c.line, Macro: c.macro.String(), Err: err}
+}
+
+// arg returns argument n (starting from 1) evaluated in the caller's
+// environment. Leading and trailing spaces are removed before evaluation.
+func (e *Engine) arg(c *call, n int) (string, error) {
+	sb := &strings.Builder{}
+	if err := e.evaluate(trim(c.args[n-1].text), c.frame, sb); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+func (e *Engine) defineOperations() {
+	for _, o := range []struct {
+		structure string
+		operation operation
+	}{
+		{"MCDEF AS NL", mcdef(false)},
+		{"MCDEFG AS NL", mcdef(true)},
+		{"MCSKIP NL", mcskip(false)},
+		{"MCSKIPG NL", mcskip(true)},
+		{"MCINS NL", mcins(false)},
+		{"MCINSG NL", mcins(true)},
+		{"MCWARN NL", mcwarn(false)},
+		{"MCWARNG NL", mcwarn(true)},
+		{"MCSET = NL", mcset},
+		{"MCGO NL", mcgo},
+		{"MCPVAR NL", mcpvar},
+		{"MCNOTE NL", mcnote},
+		{"MCNODEF NL", mcno(macroKind)},
+		{"MCNOINS NL", mcno(insertKind)},
+		{"MCNOSKIP NL", mcno(skipKind)},
+		{"MCNOWARN NL", mcno(warnKind)},
+		{"MCLENG WITH ( )", mcleng},
+		{"MCSUB WITH ( , , )", mcsub},
+		{"MCALTER NL", mcalter},
+	} {
+		name, delims, err := parseStructure(o.structure)
+		if err != nil {
+			panic(fmt.Sprintf("assert(%q: %v)", o.structure, err))
+		}
+		e.global = append(e.global, &construction{kind: macroKind, name: name, delims: delims, operation: o.operation})
+	}
+}
+
+// define adds the construction to the environment of the caller.
+// Constructions defined inside a macro call are local to that call.
+func (e *Engine) define(c *construction, f *frame, global bool) {
+	if global || f == nil {
+		e.global = append(e.global, c)
+		return
+	}
+	f.locals = append(f.locals, c)
+}
+
+// mcdef returns the operation that implements MCDEF (and MCDEFG).
+func mcdef(global bool) operation {
+	return func(e *Engine, c *call) error {
+		structure, err := e.arg(c, 1)
+		if err != nil {
+			return c.error(err)
+		}
+		replacement, err := e.arg(c, 2)
+		if err != nil {
+			return c.error(err)
+		}
+		m, err := newMacro(structure, replacement, c.line)
+		if err != nil {
+			return c.error(err)
+		}
+		e.define(m, c.frame, global)
+		return nil
+	}
+}
+
+// options splits "MT, < >" into options and structure.
+// The options are only present if the text before the first comma
+// is made up of the letters D, M, and T.
+func options(text string) (string, string) {
+	before, after, found := strings.Cut(text, ",")
+	if !found || strings.TrimSpace(before) == "" || strings.Trim(before, "DMT ") != "" {
+		return "", text
+	}
+	return before, after
+}
+
+// mcskip returns the operation that implements MCSKIP (and MCSKIPG).
+func mcskip(global bool) operation {
+	return func(e *Engine, c *call) error {
+		text, err := e.arg(c, 1)
+		if err != nil {
+			return c.error(err)
+		}
+		opts, structure := options(text)
+		s, err := newSkip(opts, structure)
+		if err != nil {
+			return c.error(err)
+		}
+		e.define(s, c.frame, global)
+		return nil
+	}
+}
+
+// mcins returns the operation that implements MCINS (and MCINSG).
+func mcins(global bool) operation {
+	return func(e *Engine, c *call) error {
+		text, err := e.arg(c, 1)
+		if err != nil {
+			return c.error(err)
+		}
+		_, structure := options(text)
+		i, err := newInsert(structure)
+		if err != nil {
+			return c.error(err)
+		}
+		e.define(i, c.frame, global)
+		return nil
+	}
+}
+
+// mcwarn returns the operation that implements MCWARN (and MCWARNG).
+func mcwarn(global bool) operation {
+	return func(e *Engine, c *call) error {
+		text, err := e.arg(c, 1)
+		if err != nil {
+			return c.error(err)
+		}
+		w, err := newWarning(text)
+		if err != nil {
+			return c.error(err)
+		}
+		e.define(w, c.frame, global)
+		return nil
+	}
+}
+
+func mcset(e *Engine, c *call) error {
+	name, err := e.arg(c, 1)
+	if err != nil {
+		return c.error(err)
+	}
+	expr, err := e.arg(c, 2)
+	if err != nil {
+		return c.error(err)
+	}
+	value, err := e.expression(expr, c.frame)
+	if err != nil {
+		return c.error(fmt.Errorf("%q: %w", expr, err))
+	}
+	if err := e.setVariable(strings.TrimSpace(name), value, c.frame); err != nil {
+		return c.error(err)
+	}
+	return nil
+}
+
+func mcgo(e *Engine, c *call) error {
+	if c.frame == nil {
+		return c.error(ErrMCGOOutsideMacro)
+	}
+	text, err := e.arg(c, 1)
+	if err != nil {
+		return c.error(err)
+	}
+	fields := strings.Fields(text)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "L") {
+		return c.error(fmt.Errorf("%q: want label: %w", text, ErrUndefinedLabel))
+	}
+	label, err := strconv.Atoi(fields[0][1:])
+	if err != nil || label < 0 {
+		return c.error(fmt.Errorf("%q: %w", fields[0], ErrUndefinedLabel))
+	}
+
+	if len(fields) > 1 {
+		var want bool
+		switch fields[1] {
+		case "IF":
+			want = true
+		case "UNLESS":
+			want = false
+		default:
+			return c.error(fmt.Errorf("%q: want IF or UNLESS: %w", text, ErrBadCondition))
+		}
+		_, condition, _ := strings.Cut(text, fields[1])
+		got, err := e.condition(condition, c.frame)
+		if err != nil {
+			return c.error(err)
+		} else if got != want {
+			return nil
+		}
+	}
+
+	return &jump{frame: c.frame, label: label}
+}
+
+// condition evaluates "a = b" as a string comparison or "a OP b"
+// as an integer comparison where OP is one of EN, NE, GR, GE, LT or LE.
+func (e *Engine) condition(text string, f *frame) (bool, error) {
+	fields := strings.Fields(text)
+	for i, field := range fields {
+		switch field {
+		case "EN", "NE", "GR", "GE", "LT", "LE":
+			a, err := e.expression(strings.Join(fields[:i], " "), f)
+			if err != nil {
+				return false, fmt.Errorf("%q: %w", text, err)
+			}
+			b, err := e.expression(strings.Join(fields[i+1:], " "), f)
+			if err != nil {
+				return false, fmt.Errorf("%q: %w", text, err)
+			}
+			switch field {
+			case "EN":
+				return a == b, nil
+			case "NE":
+				return a != b, nil
+			case "GR":
+				return a > b, nil
+			case "GE":
+				return a >= b, nil
+			case "LT":
+				return a < b, nil
+			default:
+				return a <= b, nil
+			}
+		}
+	}
+	a, b, found := strings.Cut(text, "=")
+	if !found {
+		return false, fmt.Errorf("%q: %w", text, ErrBadCondition)
+	}
+	return strings.TrimSpace(a) == strings.TrimSpace(b), nil
+}
+
+func mcpvar(e *Engine, c *call) error {
+	text, err := e.arg(c, 1)
+	if err != nil {
+		return c.error(err)
+	}
+	n, err := e.expression(text, c.frame)
+	if err != nil || n < 0 {
+		return c.error(fmt.Errorf("%q: %w", text, ErrBadExpression))
+	}
+	perm := make([]int, n)
+	copy(perm, e.perm)
+	e.perm = perm
+	return nil
+}
+
+func mcnote(e *Engine, c *call) error {
+	text, err := e.arg(c, 1)
+	if err != nil {
+		return c.error(err)
+	}
+	if e.Messages != nil {
+		_, _ = fmt.Fprintln(e.Messages, text)
+	}
+	return nil
+}
+
+// mcno returns the operation that implements MCNODEF, MCNOINS, MCNOSKIP, and MCNOWARN.
+// The construction is hidden in the environment of the caller, so a name
+// hidden inside a macro call is visible again when the call returns.
+func mcno(k kind) operation {
+	return func(e *Engine, c *call) error {
+		text, err := e.arg(c, 1)
+		if err != nil {
+			return c.error(err)
+		}
+		name, delims, err := parseStructure(text)
+		if err != nil {
+			return c.error(err)
+		} else if len(delims.delims) != 0 {
+			return c.error(fmt.Errorf("%q: want name only: %w", text, ErrBadStructure))
+		}
+		e.define(&construction{kind: k, name: name, hidden: true}, c.frame, false)
+		return nil
+	}
+}
+
+// mcleng writes the number of characters in its argument.
+func mcleng(e *Engine, c *call) error {
+	text, err := e.arg(c, 1)
+	if err != nil {
+		return c.error(err)
+	}
+	c.out.WriteString(strconv.Itoa(len([]rune(text))))
+	return nil
+}
+
+// mcsub writes characters n through m of its first argument,
+// counting from 1. If m is less than n, nothing is written.
+func mcsub(e *Engine, c *call) error {
+	text, err := e.arg(c, 1)
+	if err != nil {
+		return c.error(err)
+	}
+	var bounds [2]int
+	for i := range bounds {
+		expr, err := e.arg(c, i+2)
+		if err != nil {
+			return c.error(err)
+		} else if bounds[i], err = e.expression(expr, c.frame); err != nil {
+			return c.error(fmt.Errorf("%q: %w", expr, err))
+		}
+	}
+	runes, n, m := []rune(text), bounds[0], bounds[1]
+	if n < 1 || n > len(runes)+1 || m < n-1 || m > len(runes) {
+		return c.error(fmt.Errorf("%d, %d: %w", n, m, ErrArgumentOutOfRange))
+	}
+	c.out.WriteString(string(runes[n-1 : m]))
+	return nil
+}
+
+// mcalter is not supported.
+func mcalter(e *Engine, c *call) error {
+	return c.error(ErrUnsupported)
+}
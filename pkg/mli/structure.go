@@ -0,0 +1,284 @@
+// ml_i - an ML/I macro processor ported to Go
+// Copyright (c) 2023 Michael D Henderson.
+// All rights reserved.
+
+package mli
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// kind is the kind of a construction.
+type kind int
+
+const (
+	macroKind kind = iota
+	insertKind
+	skipKind
+	warnKind
+)
+
+// construction is a macro, insert, skip, or warning marker.
+type construction struct {
+	kind   kind
+	name   []string // atoms of the name
+	delims *node    // the delimiters that may follow the name
+	hidden bool     // hides older constructions of the same kind and name
+	// macros are either user defined (replacement text) or operation macros
+	replacement []atom
+	operation   operation
+	// skip options
+	matched bool // M - nested name and closing delimiter pairs are matched
+	text    bool // T - the text of the skip is copied to the output
+	delim   bool // D - the name and closing delimiter are copied to the output
+}
+
+// String implements the Stringer interface.
+func (c *construction) String() string {
+	return strings.Join(c.name, "")
+}
+
+// node is a point in a delimiter structure. The delimiters are the
+// choices at that point; a node without delimiters ends the structure.
+type node struct {
+	delims []*delimiter
+	ref    string // a reference to node Nn, replaced when the structure is linked
+}
+
+// delimiter is a delimiter and the node that follows it.
+type delimiter struct {
+	atoms []string
+	next  *node
+}
+
+// closing returns true if the delimiter ends the construction.
+func (d *delimiter) closing() bool {
+	return len(d.next.delims) == 0
+}
+
+// match returns the first delimiter at the node that matches the atoms
+// starting at pos, or nil if none match.
+func (n *node) match(text []atom, pos int) *delimiter {
+	for _, d := range n.delims {
+		if matches(text, pos, d.atoms) {
+			return d
+		}
+	}
+	return nil
+}
+
+// String implements the Stringer interface.
+func (n *node) String() string {
+	var choices []string
+	for _, d := range n.delims {
+		choices = append(choices, strconv.Quote(strings.Join(d.atoms, "")))
+	}
+	return strings.Join(choices, " or ")
+}
+
+// element is an item of a delimiter structure: a delimiter, a node
+// (Nn), or an OPT with its branches.
+type element struct {
+	delim    []string
+	node     string      // name of the node
+	ref      bool        // true if the node was placed by an earlier element
+	branches [][]element // OPT ... OR ... ALL
+}
+
+// parseStructure parses a structure representation into a name and
+// the delimiters that may follow it. The items in the representation
+// are separated by spaces, and each atom is a separate item unless
+// joined with WITH. The keywords NL, SPACE, and TAB stand for new-line,
+// space, and tab.
+//
+// The keywords OPT, OR, and ALL give a choice of branches, each of
+// which must start with a delimiter, e.g. "IF THEN OPT ELSE END OR END ALL".
+// The first occurrence of a node Nn marks a point in the structure, and
+// later occurrences, which must end a branch, continue from that point,
+// e.g. "SUM N1 OPT + N1 OR ; ALL".
+func parseStructure(text string) (name []string, delims *node, err error) {
+	var items [][]string
+	join := false
+	for _, a := range atomize(text, 1) {
+		if a.isspace() {
+			continue
+		}
+		if a.text == "WITH" {
+			if len(items) == 0 || join {
+				return nil, nil, fmt.Errorf("%q: misplaced WITH: %w", text, ErrBadStructure)
+			}
+			join = true
+			continue
+		}
+		t := a.text
+		switch t {
+		case "NL":
+			t = "\n"
+		case "SPACE":
+			t = " "
+		case "TAB":
+			t = "\t"
+		}
+		if join {
+			items[len(items)-1] = append(items[len(items)-1], t)
+			join = false
+		} else {
+			items = append(items, []string{t})
+		}
+	}
+	if join {
+		return nil, nil, fmt.Errorf("%q: dangling WITH: %w", text, ErrBadStructure)
+	} else if len(items) == 0 {
+		return nil, nil, fmt.Errorf("%q: missing name: %w", text, ErrBadStructure)
+	} else if keyword(items[0]) != "" {
+		return nil, nil, fmt.Errorf("%q: %s: want name: %w", text, items[0][0], ErrBadStructure)
+	}
+
+	placed := map[string]bool{}
+	elements, pos, err := parseElements(items, 1, placed)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%q: %w", text, err)
+	} else if pos < len(items) {
+		return nil, nil, fmt.Errorf("%q: misplaced %s: %w", text, items[pos][0], ErrBadStructure)
+	}
+
+	// build the nodes from the end of the structure back to the name,
+	// then replace the references with the nodes that they refer to
+	nodes := map[string]*node{}
+	delims = build(elements, &node{}, nodes)
+	if delims, err = link(delims, nodes, map[*node]bool{}); err != nil {
+		return nil, nil, fmt.Errorf("%q: %w", text, err)
+	}
+	return items[0], delims, nil
+}
+
+// keyword returns the structure keyword (OPT, OR, ALL, or N for a node)
+// of the item, or an empty string if the item is a delimiter.
+func keyword(item []string) string {
+	if len(item) != 1 {
+		return ""
+	}
+	switch t := item[0]; t {
+	case "OPT", "OR", "ALL":
+		return t
+	default:
+		if len(t) > 1 && t[0] == 'N' && strings.Trim(t[1:], "0123456789") == "" {
+			return "N"
+		}
+	}
+	return ""
+}
+
+// parseElements parses the items starting at pos up to OR, ALL, or the
+// end of the items. It returns the elements and the position of the
+// item that ended them.
+func parseElements(items [][]string, pos int, placed map[string]bool) ([]element, int, error) {
+	var elements []element
+	for ; pos < len(items); pos++ {
+		item := items[pos]
+		kw := keyword(item)
+		if kw == "OR" || kw == "ALL" {
+			return elements, pos, nil
+		} else if n := len(elements); n != 0 && elements[n-1].ref {
+			return nil, pos, fmt.Errorf("%s: must end a branch: %w", elements[n-1].node, ErrBadStructure)
+		}
+		switch kw {
+		case "OPT":
+			var el element
+			for {
+				branch, end, err := parseElements(items, pos+1, placed)
+				if err != nil {
+					return nil, end, err
+				} else if !startsWithDelimiter(branch) {
+					return nil, end, fmt.Errorf("OPT: branch must start with a delimiter: %w", ErrBadStructure)
+				} else if end == len(items) {
+					return nil, end, fmt.Errorf("OPT: missing ALL: %w", ErrBadStructure)
+				}
+				el.branches, pos = append(el.branches, branch), end
+				if keyword(items[end]) == "ALL" {
+					break
+				}
+			}
+			elements = append(elements, el)
+		case "N":
+			elements = append(elements, element{node: item[0], ref: placed[item[0]]})
+			placed[item[0]] = true
+		default:
+			elements = append(elements, element{delim: item})
+		}
+	}
+	return elements, pos, nil
+}
+
+// startsWithDelimiter returns true if the first element that is not
+// a node is a delimiter or an OPT.
+func startsWithDelimiter(elements []element) bool {
+	for _, el := range elements {
+		if el.node == "" {
+			return true
+		} else if el.ref {
+			return false
+		}
+	}
+	return false
+}
+
+// build returns the node at the start of the elements, given the node
+// that follows them. Nodes that are placed are added to nodes.
+func build(elements []element, next *node, nodes map[string]*node) *node {
+	for i := len(elements) - 1; i >= 0; i-- {
+		switch el := elements[i]; {
+		case el.branches != nil:
+			opt := &node{}
+			for _, branch := range el.branches {
+				opt.delims = append(opt.delims, build(branch, next, nodes).delims...)
+			}
+			next = opt
+		case el.ref:
+			next = &node{ref: el.node}
+		case el.node != "":
+			nodes[el.node] = next
+		default:
+			next = &node{delims: []*delimiter{{atoms: el.delim, next: next}}}
+		}
+	}
+	return next
+}
+
+// link replaces references to nodes with the nodes that they refer to.
+// It returns the node that n refers to.
+func link(n *node, nodes map[string]*node, seen map[*node]bool) (*node, error) {
+	for refs := map[string]bool{}; n.ref != ""; n = nodes[n.ref] {
+		if refs[n.ref] {
+			return nil, fmt.Errorf("%s: no delimiter before reference: %w", n.ref, ErrBadStructure)
+		}
+		refs[n.ref] = true
+	}
+	if seen[n] {
+		return n, nil
+	}
+	seen[n] = true
+	for _, d := range n.delims {
+		next, err := link(d.next, nodes, seen)
+		if err != nil {
+			return nil, err
+		}
+		d.next = next
+	}
+	return n, nil
+}
+
+// matches returns true if the atoms starting at pos match the sequence.
+func matches(text []atom, pos int, seq []string) bool {
+	if pos+len(seq) > len(text) {
+		return false
+	}
+	for i, s := range seq {
+		if text[pos+i].text != s {
+			return false
+		}
+	}
+	return true
+}